@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// DefaultRegisterLimit is the page size of the account register
+const DefaultRegisterLimit = 100
+
+// RegisterEntry is a transaction with the account balance right after it
+type RegisterEntry struct {
+	TransactionWithRelations
+	RunningBalance float64 `db:"running_balance" json:"running_balance"`
+}
+
+// Register is one page of an account register
+type Register struct {
+	AccountID      int64           `json:"account_id"`
+	Balance        float64         `json:"balance"`
+	OpeningBalance float64         `json:"opening_balance"`
+	Total          int             `json:"total"`
+	Limit          int             `json:"limit"`
+	Offset         int             `json:"offset"`
+	Transactions   []RegisterEntry `json:"transactions"`
+}
+
+// GetAccountRegister lists an account's transactions in date order with the
+// balance after each one. Running balances are computed over the whole
+// register before paging, and the last one always equals accounts.balance.
+func GetAccountRegister(accountID int64, limit, offset int, descending bool) (*Register, error) {
+	account, err := NewRepository[Account](db, "accounts", "id").GetByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := &Register{
+		AccountID:    accountID,
+		Balance:      account.Balance,
+		Limit:        limit,
+		Offset:       offset,
+		Transactions: []RegisterEntry{},
+	}
+
+	var sum float64
+	err = db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(amount), 0)
+		FROM transactions
+		WHERE account_id = ? AND is_deleted = 0
+	`, accountID).Scan(&reg.Total, &sum)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transactions subtract from the balance, so adding them all back gives
+	// the balance before the first one
+	reg.OpeningBalance = account.Balance + sum
+
+	order := "ASC"
+	if descending {
+		order = "DESC"
+	}
+
+	err = db.Select(&reg.Transactions, `
+		SELECT * FROM (
+			SELECT
+				t.*,
+				CASE WHEN t.amount < 0 THEN 'inflow' ELSE 'outflow' END AS direction,
+				a.name AS account_name,
+				c.name AS category_name,
+				? - SUM(t.amount) OVER (
+					ORDER BY date(t.date), t.date, t.id
+					ROWS UNBOUNDED PRECEDING
+				) AS running_balance
+			FROM transactions t
+			JOIN accounts a ON a.id = t.account_id
+			LEFT JOIN categories c ON c.id = t.category_id
+			WHERE t.account_id = ? AND t.is_deleted = 0
+		)
+		ORDER BY date(date) `+order+`, date `+order+`, id `+order+`
+		LIMIT ? OFFSET ?
+	`, reg.OpeningBalance, accountID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(reg.Transactions))
+	for i := range reg.Transactions {
+		ids[i] = reg.Transactions[i].ID
+	}
+	tags, err := LoadTransactionTags(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range reg.Transactions {
+		e := &reg.Transactions[i]
+		e.Tags = tags[e.ID]
+		if e.Tags == nil {
+			e.Tags = []string{}
+		}
+	}
+	return reg, nil
+}
+
+// HandleGetAccountRegister handles GET /accounts/{id}/register?limit=...&offset=...&order=asc|desc
+func HandleGetAccountRegister(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	limit, offset := DefaultRegisterLimit, 0
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	reg, err := GetAccountRegister(id, limit, offset, q.Get("order") == "desc")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, reg)
+}
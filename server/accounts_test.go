@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAccountRegisterTags(t *testing.T) {
+	l := newLedger(t)
+
+	tagged, err := CreateTransaction(l.account, &l.food, nil, nil, 30, "2026-01-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	untagged, err := CreateTransaction(l.account, &l.food, nil, nil, 20, "2026-01-16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := BulkTag(BulkTagRequest{TransactionIDs: []int64{tagged}, Add: []string{"Work", "trip"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	reg, err := GetAccountRegister(l.account, DefaultRegisterLimit, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64][]string{tagged: {"trip", "work"}, untagged: {}}
+	if len(reg.Transactions) != len(want) {
+		t.Fatalf("register has %d transactions, want %d", len(reg.Transactions), len(want))
+	}
+	for _, e := range reg.Transactions {
+		if !reflect.DeepEqual(e.Tags, want[e.ID]) {
+			t.Errorf("transaction %d tags = %#v, want %#v", e.ID, e.Tags, want[e.ID])
+		}
+	}
+}
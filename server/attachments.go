@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// MaxAttachmentSize is the largest file accepted as an attachment
+const MaxAttachmentSize = 10 << 20
+
+// AttachmentTypes are the sniffed content types accepted as attachments
+var AttachmentTypes = map[string]bool{
+	"application/pdf": true,
+	"image/gif":       true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/webp":      true,
+	"text/plain":      true,
+}
+
+// Attachment is a file kept with a transaction, stored in the database.
+// Data is only loaded when the file is downloaded or exported.
+type Attachment struct {
+	ID            int64     `json:"id"`
+	TransactionID int64     `db:"transaction_id" json:"transaction_id"`
+	Filename      string    `json:"filename"`
+	ContentType   string    `db:"content_type" json:"content_type"`
+	Size          int64     `json:"size"`
+	SHA256        string    `db:"sha256" json:"sha256"`
+	Data          []byte    `json:"-"`
+	CreatedAt     Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt     Timestamp `db:"updated_at" json:"updated_at"`
+	IsDeleted     int       `db:"is_deleted" json:"is_deleted"`
+}
+
+// attachmentColumns are the columns of attachments without the data
+const attachmentColumns = `id, transaction_id, filename, content_type, size, sha256, x'' AS data, created_at, updated_at, is_deleted`
+
+// SniffContentType detects the content type of data, ignoring whatever
+// the client claimed
+func SniffContentType(data []byte) string {
+	t, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return t
+}
+
+// CreateAttachment stores data as an attachment of a live transaction. The
+// same file can only be attached to a transaction once.
+func CreateAttachment(transactionID int64, filename string, data []byte) (int64, error) {
+	if len(data) == 0 {
+		return 0, BadRequest("file is empty")
+	}
+	if len(data) > MaxAttachmentSize {
+		return 0, &HTTPError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("attachments are limited to %d bytes", MaxAttachmentSize),
+		}
+	}
+
+	contentType := SniffContentType(data)
+	if !AttachmentTypes[contentType] {
+		return 0, &HTTPError{
+			Status:  http.StatusUnsupportedMediaType,
+			Message: fmt.Sprintf("%s files can't be attached", contentType),
+		}
+	}
+
+	filename = filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "." || filename == "/" {
+		filename = "attachment"
+	}
+
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var alive int
+	if err := tx.Get(&alive, `SELECT COUNT(*) FROM transactions WHERE id = ? AND is_deleted = 0`, transactionID); err != nil {
+		return 0, err
+	}
+	if alive == 0 {
+		return 0, NotFound("transaction %d not found", transactionID)
+	}
+
+	var existing []int64
+	err = tx.Select(&existing, `
+		SELECT id FROM attachments WHERE transaction_id = ? AND sha256 = ? AND is_deleted = 0
+	`, transactionID, checksum)
+	if err != nil {
+		return 0, err
+	}
+	if len(existing) > 0 {
+		return 0, Conflict("this file is already attachment %d", existing[0])
+	}
+
+	var id int64
+	err = tx.Get(&id, `
+		INSERT INTO attachments (transaction_id, filename, content_type, size, sha256, data)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, transactionID, filename, contentType, len(data), checksum, data)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("[DB][OK] create_attachment(transaction_id=%d, filename=%s, content_type=%s, size=%d) id=%d\n",
+		transactionID, filename, contentType, len(data), id)
+	return id, nil
+}
+
+// ListAttachments lists a transaction's live attachments, without their data
+func ListAttachments(transactionID int64) ([]Attachment, error) {
+	attachments := []Attachment{}
+	err := db.Select(&attachments, `
+		SELECT `+attachmentColumns+` FROM attachments
+		WHERE transaction_id = ? AND is_deleted = 0
+		ORDER BY id
+	`, transactionID)
+	return attachments, err
+}
+
+// listDeletedAttachments is the attachments trash, without the data
+func listDeletedAttachments() (any, error) {
+	attachments := []Attachment{}
+	err := db.Select(&attachments, `
+		SELECT `+attachmentColumns+` FROM attachments
+		WHERE is_deleted = 1
+		ORDER BY updated_at DESC
+	`)
+	return attachments, err
+}
+
+// PurgeAttachments permanently removes attachments deleted at least
+// olderThanDays ago, and the attachments of purged transactions
+func PurgeAttachments(olderThanDays int) (int64, error) {
+	res, err := db.Exec(`
+		DELETE FROM attachments
+		WHERE (is_deleted = 1 AND updated_at <= datetime('now', ?))
+		   OR transaction_id NOT IN (SELECT id FROM transactions)
+	`, purgeCutoff(olderThanDays))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("[DB][OK] purge_attachments(older_than_days=%d) removed=%d\n", olderThanDays, n)
+	return n, nil
+}
+
+// HandleGetAttachments handles GET /transactions/{id}/attachments
+func HandleGetAttachments(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	attachments, err := ListAttachments(id)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, attachments)
+}
+
+// HandleUploadAttachment handles POST /transactions/{id}/attachments, a
+// multipart/form-data upload with the file in the "file" field
+func HandleUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	// Leave room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, MaxAttachmentSize+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Expected a multipart upload with a file field", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxAttachmentSize+1))
+	if err != nil {
+		http.Error(w, "Upload failed", http.StatusBadRequest)
+		return
+	}
+
+	attachmentID, err := CreateAttachment(id, header.Filename, data)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"status": "OK",
+		"id":     attachmentID,
+	})
+}
+
+// HandleDownloadAttachment handles GET /attachments/{id}
+func HandleDownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	a, err := NewRepository[Attachment](db, "attachments", "id").GetByID(id)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Length", fmt.Sprint(a.Size))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	w.Header().Set("ETag", `"`+a.SHA256+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(a.Data)
+}
+
+// HandleDeleteAttachment handles DELETE /attachments/{id}
+func HandleDeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	repo := NewRepository[Attachment](db, "attachments", "id")
+	if _, err := repo.GetByID(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := repo.Delete(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
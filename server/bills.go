@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// UpcomingBill is a scheduled occurrence with its account's projected
+// balance once it has posted
+type UpcomingBill struct {
+	Occurrence
+	AccountName      string  `json:"account_name"`
+	IsBill           bool    `json:"is_bill"`
+	ProjectedBalance float64 `json:"projected_balance"`
+}
+
+// AccountProjection summarizes where an account is heading over the window
+type AccountProjection struct {
+	AccountID      int64   `json:"account_id"`
+	Name           string  `json:"name"`
+	Balance        float64 `json:"balance"`
+	EndBalance     float64 `json:"end_balance"`
+	LowestBalance  float64 `json:"lowest_balance"`
+	LowestDate     string  `json:"lowest_date,omitempty"`
+	GoesNegative   bool    `json:"goes_negative"`
+	FirstShortfall string  `json:"first_shortfall,omitempty"`
+}
+
+// BillsFeed is the response of GET /bills/upcoming
+type BillsFeed struct {
+	From     string              `json:"from"`
+	To       string              `json:"to"`
+	Bills    []UpcomingBill      `json:"bills"`
+	Accounts []AccountProjection `json:"accounts"`
+	Warnings []string            `json:"warnings"`
+}
+
+// GetUpcomingBills walks the scheduled occurrences of the next days in date
+// order, projecting each account's balance after every one of them from its
+// current balance. Outflows are bills; inflows are listed too because they
+// move the projection. Liability accounts are expected to be negative and
+// are never warned about.
+func GetUpcomingBills(today string, days int) (*BillsFeed, error) {
+	upcoming, err := UpcomingOccurrences(today, days)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := NewRepository[Account](db, "accounts", "id").List(WithOrderBy("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	t, _ := parseDate(today)
+	feed := &BillsFeed{
+		From:     today,
+		To:       t.AddDate(0, 0, days).Format(DateLayout),
+		Bills:    []UpcomingBill{},
+		Accounts: []AccountProjection{},
+		Warnings: []string{},
+	}
+
+	projections := map[int64]*AccountProjection{}
+	liability := map[int64]bool{}
+	for _, a := range accounts {
+		liability[a.ID] = AccountTypes[a.Type]
+		feed.Accounts = append(feed.Accounts, AccountProjection{
+			AccountID:     a.ID,
+			Name:          a.Name,
+			Balance:       a.Balance,
+			EndBalance:    a.Balance,
+			LowestBalance: a.Balance,
+		})
+	}
+	for i := range feed.Accounts {
+		projections[feed.Accounts[i].AccountID] = &feed.Accounts[i]
+	}
+
+	for _, o := range upcoming {
+		p, ok := projections[o.AccountID]
+		if !ok {
+			// Scheduled against a deleted account, it can't post
+			continue
+		}
+
+		p.EndBalance -= o.Amount
+		if p.EndBalance < p.LowestBalance {
+			p.LowestBalance = p.EndBalance
+			p.LowestDate = o.Date
+		}
+
+		if p.EndBalance < 0 && !liability[o.AccountID] && !p.GoesNegative {
+			p.GoesNegative = true
+			p.FirstShortfall = o.Date
+			feed.Warnings = append(feed.Warnings, fmt.Sprintf(
+				"%s is projected to be overdrawn (%.2f) on %s", p.Name, p.EndBalance, o.Date))
+		}
+
+		feed.Bills = append(feed.Bills, UpcomingBill{
+			Occurrence:       o,
+			AccountName:      p.Name,
+			IsBill:           o.Amount > 0,
+			ProjectedBalance: p.EndBalance,
+		})
+	}
+	return feed, nil
+}
+
+// HandleUpcomingBills handles GET /bills/upcoming?days=N
+func HandleUpcomingBills(w http.ResponseWriter, r *http.Request) {
+	days := DefaultUpcomingDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		var err error
+		if days, err = strconv.Atoi(v); err != nil || days < 0 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+	}
+
+	feed, err := GetUpcomingBills(Today().Format(DateLayout), days)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, feed)
+}
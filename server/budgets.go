@@ -0,0 +1,360 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// MonthLayout is the format of budget months
+const MonthLayout = "2006-01"
+
+// Budget is a category's budget assignment for one month
+type Budget struct {
+	ID         int64     `json:"id"`
+	CategoryID int64     `db:"category_id" json:"category_id"`
+	Month      string    `json:"month"`
+	Amount     float64   `json:"amount"`
+	CreatedAt  Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt  Timestamp `db:"updated_at" json:"updated_at"`
+	IsDeleted  int       `db:"is_deleted" json:"is_deleted"`
+}
+
+type SetBudgetRequest struct {
+	CategoryID int64   `json:"category_id"`
+	Month      string  `json:"month"`
+	Amount     float64 `json:"amount"`
+}
+
+// SetBudget assigns amount to a category for month, replacing any earlier
+// assignment
+func SetBudget(categoryID int64, month string, amount float64) (int64, error) {
+	if _, err := time.Parse(MonthLayout, month); err != nil {
+		return 0, BadRequest("month must be a YYYY-MM month")
+	}
+
+	var alive int
+	if err := db.Get(&alive, `SELECT COUNT(*) FROM categories WHERE id = ? AND is_deleted = 0`, categoryID); err != nil {
+		return 0, err
+	}
+	if alive == 0 {
+		return 0, NotFound("category %d not found", categoryID)
+	}
+
+	var id int64
+	err := db.Get(&id, `
+		INSERT INTO budgets (category_id, month, amount)
+		VALUES (?, ?, ?)
+		ON CONFLICT (category_id, month) DO UPDATE
+		SET amount = excluded.amount, is_deleted = 0, updated_at = CURRENT_TIMESTAMP
+		RETURNING id
+	`, categoryID, month, amount)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("[DB][OK] set_budget(category_id=%d, month=%s, amount=%v) id=%d\n", categoryID, month, amount, id)
+	return id, nil
+}
+
+// HandleSetBudget handles PUT /budgets
+func HandleSetBudget(w http.ResponseWriter, r *http.Request) {
+	var req SetBudgetRequest
+
+	HandleCreate(
+		w,
+		r,
+		&req,
+		func(r SetBudgetRequest) error {
+			if r.CategoryID == 0 {
+				return BadRequest("category id is required")
+			}
+			return nil
+		},
+		func(r SetBudgetRequest) (int64, error) {
+			return SetBudget(r.CategoryID, r.Month, r.Amount)
+		},
+	)
+}
+
+// HandleGetBudgets handles GET /budgets?month=YYYY-MM or ?period=..., which
+// lists the budgets of the months in the period
+func HandleGetBudgets(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := []ListOption{WithOrderBy("month, category_id")}
+	if month := q.Get("month"); month != "" {
+		opts = append(opts, WithWhere("month = ?", month))
+	}
+	if period := q.Get("period"); period != "" {
+		start, end, err := ResolvePeriod(period, Today())
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		months := monthsIn(Period{Start: start, End: end})
+		opts = append(opts, WithWhere("month BETWEEN ? AND ?", months[0], months[len(months)-1]))
+	}
+
+	budgets, err := NewRepository[Budget](db, "budgets", "id").List(opts...)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if budgets == nil {
+		budgets = []Budget{}
+	}
+
+	WriteJSON(w, budgets)
+}
+
+// BudgetRow compares a category's budget with its spending in one period.
+// PercentUsed is nil when nothing was budgeted. With compare, Previous is
+// the category's row a year earlier and Change the percent change of the
+// actual spending.
+type BudgetRow struct {
+	Period      string     `json:"period"`
+	CategoryID  int64      `json:"category_id"`
+	Name        string     `json:"name"`
+	ParentID    *int64     `json:"parent_id,omitempty"`
+	Budgeted    float64    `json:"budgeted"`
+	Actual      float64    `json:"actual"`
+	Variance    float64    `json:"variance"`
+	PercentUsed *float64   `json:"percent_used"`
+	Overspent   bool       `json:"overspent"`
+	Previous    *BudgetRow `json:"previous,omitempty"`
+	Change      *float64   `json:"change,omitempty"`
+}
+
+// BudgetReport is the response of GET /reports/budget
+type BudgetReport struct {
+	ReportRange
+	Rows []BudgetRow `json:"rows"`
+}
+
+// budgetFigures are a category's budgeted and actual amounts per month
+type budgetFigures struct {
+	target  *float64
+	budgets map[string]float64
+	actuals map[string]float64
+}
+
+type monthlyAmount struct {
+	CategoryID int64   `db:"category_id"`
+	Month      string  `db:"month"`
+	Amount     float64 `db:"amount"`
+}
+
+// budgetedFor is what a category had budgeted for month: its assignment for
+// that month, or else its standing target
+func (f *budgetFigures) budgetedFor(month string) float64 {
+	if amount, ok := f.budgets[month]; ok {
+		return amount
+	}
+	if f.target != nil {
+		return *f.target
+	}
+	return 0
+}
+
+// loadBudgetFigures collects assignments, spending and standing targets for
+// every category over the months fromMonth..toMonth. A category's standing
+// target is its amount before any transactions were taken off it.
+func loadBudgetFigures(rr ReportRange, fromMonth, toMonth string) (map[int64]*budgetFigures, error) {
+	figures := map[int64]*budgetFigures{}
+	get := func(id int64) *budgetFigures {
+		f, ok := figures[id]
+		if !ok {
+			f = &budgetFigures{budgets: map[string]float64{}, actuals: map[string]float64{}}
+			figures[id] = f
+		}
+		return f
+	}
+
+	var targets []struct {
+		ID     int64    `db:"id"`
+		Target *float64 `db:"target"`
+	}
+	err := db.Select(&targets, `
+		SELECT c.id, c.amount + COALESCE((
+			SELECT SUM(t.amount) FROM transactions t
+			WHERE t.category_id = c.id AND t.is_deleted = 0
+		), 0) AS target
+		FROM categories c
+		WHERE c.is_deleted = 0
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		get(t.ID).target = t.Target
+	}
+
+	var budgets []monthlyAmount
+	err = db.Select(&budgets, `
+		SELECT category_id, month, amount FROM budgets
+		WHERE is_deleted = 0 AND month BETWEEN ? AND ?
+	`, fromMonth, toMonth)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range budgets {
+		get(b.CategoryID).budgets[b.Month] = b.Amount
+	}
+
+	month, _ := BucketExpr("month", "date")
+	var actuals []monthlyAmount
+	err = db.Select(&actuals, `
+		SELECT category_id, `+month+` AS month, SUM(amount) AS amount
+		FROM transactions
+		WHERE is_deleted = 0
+		  AND category_id IS NOT NULL
+		  AND transfer_account_id IS NULL
+		  AND date(date) BETWEEN ? AND ?
+		GROUP BY category_id, month
+	`, rr.From, rr.To)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range actuals {
+		get(a.CategoryID).actuals[a.Month] = a.Amount
+	}
+	return figures, nil
+}
+
+// monthsIn lists the YYYY-MM months of a period. A month that starts
+// before the period only counts if no other month starts in it, which keeps
+// a year from claiming the previous December when months start mid-month.
+func monthsIn(p Period) []string {
+	var months []string
+	for m := MonthStart(p.Start); !m.After(p.End); m = m.AddDate(0, 1, 0) {
+		if m.Before(p.Start) && !m.AddDate(0, 1, 0).After(p.End) {
+			continue
+		}
+		months = append(months, m.Format(MonthLayout))
+	}
+	return months
+}
+
+// GetBudgetReport compares budgeted and actual spending per category and
+// period. Amounts roll up the category tree like SumCategoryAmounts: a parent
+// is budgeted the sum of its children and has spent its own transactions plus
+// theirs. Income categories aren't budgeted and are left out.
+func GetBudgetReport(rr ReportRange) (*BudgetReport, error) {
+	if rr.Bucket != "month" && rr.Bucket != "year" && rr.Bucket != "fiscal_year" {
+		return nil, BadRequest("the budget report supports month, year and fiscal_year buckets")
+	}
+
+	periods := Periods(rr)
+	report := &BudgetReport{ReportRange: rr, Rows: []BudgetRow{}}
+	if len(periods) == 0 {
+		return report, nil
+	}
+
+	figures, err := loadBudgetFigures(rr,
+		periods[0].Start.Format(MonthLayout),
+		periods[len(periods)-1].End.Format(MonthLayout),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := NewRepository[Category](db, "categories", "id").List(
+		WithWhere("is_income = 0"),
+		WithOrderBy("sort_order"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tree := BuildCategoryTree(categories)
+
+	for _, p := range periods {
+		months := monthsIn(p)
+
+		var walk func(c *Category) (float64, float64)
+		walk = func(c *Category) (float64, float64) {
+			var budgeted, actual float64
+			if f, ok := figures[c.ID]; ok {
+				for _, m := range months {
+					budgeted += f.budgetedFor(m)
+					actual += f.actuals[m]
+				}
+			}
+
+			// Reserve the row so parents come before their children
+			i := len(report.Rows)
+			report.Rows = append(report.Rows, BudgetRow{})
+
+			if len(c.Categories) > 0 {
+				budgeted = 0
+				for _, child := range c.Categories {
+					b, a := walk(child)
+					budgeted += b
+					actual += a
+				}
+			}
+
+			row := BudgetRow{
+				Period:     p.Label,
+				CategoryID: c.ID,
+				Name:       c.Name,
+				ParentID:   c.ParentID,
+				Budgeted:   budgeted,
+				Actual:     actual,
+				Variance:   budgeted - actual,
+				Overspent:  actual > budgeted && actual > 0,
+			}
+			if budgeted != 0 {
+				used := actual / budgeted * 100
+				row.PercentUsed = &used
+			}
+			report.Rows[i] = row
+			return budgeted, actual
+		}
+
+		for _, root := range tree {
+			walk(root)
+		}
+	}
+
+	if rr.Compare == CompareYearOverYear {
+		previous, err := GetBudgetReport(rr.PreviousYear())
+		if err != nil {
+			return nil, err
+		}
+		type key struct {
+			period   string
+			category int64
+		}
+		byKey := map[key]BudgetRow{}
+		for _, row := range previous.Rows {
+			byKey[key{row.Period, row.CategoryID}] = row
+		}
+
+		labels := previousPeriods(rr)
+		for i, row := range report.Rows {
+			if prev, ok := byKey[key{labels[row.Period], row.CategoryID}]; ok {
+				report.Rows[i].Previous = &prev
+				report.Rows[i].Change = PercentChange(row.Actual, prev.Actual)
+			}
+		}
+	}
+	return report, nil
+}
+
+// HandleBudgetReport handles
+// GET /reports/budget?from=...&to=...|period=...&bucket=month|year|fiscal_year&compare=...
+func HandleBudgetReport(w http.ResponseWriter, r *http.Request) {
+	rr, err := ParseReportRange(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	report, err := GetBudgetReport(rr)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, report)
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Settings are the user's calendar preferences. WeekStart is a weekday, 0
+// for Sunday. Months run from MonthStartDay to the day before it in the next
+// month and are named after the month they start in; fiscal years start on
+// the first of FiscalYearStartMonth.
+type Settings struct {
+	Timezone             string `json:"timezone"`
+	WeekStart            int    `json:"week_start"`
+	MonthStartDay        int    `json:"month_start_day"`
+	FiscalYearStartMonth int    `json:"fiscal_year_start_month"`
+}
+
+type UpdateSettingsRequest struct {
+	Timezone             *string `json:"timezone,omitempty"`
+	WeekStart            *int    `json:"week_start,omitempty"`
+	MonthStartDay        *int    `json:"month_start_day,omitempty"`
+	FiscalYearStartMonth *int    `json:"fiscal_year_start_month,omitempty"`
+}
+
+// DefaultSettings are used for anything not configured: the server's
+// timezone, Monday weeks and calendar months and years
+var DefaultSettings = Settings{
+	Timezone:             "Local",
+	WeekStart:            1,
+	MonthStartDay:        1,
+	FiscalYearStartMonth: 1,
+}
+
+var (
+	settingsMu sync.RWMutex
+	settings   = DefaultSettings
+	location   = time.Local
+)
+
+func (s Settings) validate() (*time.Location, error) {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, BadRequest("unknown timezone %q", s.Timezone)
+	}
+	if s.WeekStart < 0 || s.WeekStart > 6 {
+		return nil, BadRequest("week_start must be 0 (Sunday) to 6 (Saturday)")
+	}
+	// Every month has the day, so month periods never skip one
+	if s.MonthStartDay < 1 || s.MonthStartDay > 28 {
+		return nil, BadRequest("month_start_day must be 1 to 28")
+	}
+	if s.FiscalYearStartMonth < 1 || s.FiscalYearStartMonth > 12 {
+		return nil, BadRequest("fiscal_year_start_month must be 1 to 12")
+	}
+	return loc, nil
+}
+
+// LoadSettings reads the settings table into the settings in use
+func LoadSettings() error {
+	var rows []struct {
+		Key   string `db:"key"`
+		Value string `db:"value"`
+	}
+	if err := db.Select(&rows, `SELECT key, value FROM settings`); err != nil {
+		return err
+	}
+
+	s := DefaultSettings
+	for _, r := range rows {
+		var err error
+		switch r.Key {
+		case "timezone":
+			s.Timezone = r.Value
+		case "week_start":
+			s.WeekStart, err = strconv.Atoi(r.Value)
+		case "month_start_day":
+			s.MonthStartDay, err = strconv.Atoi(r.Value)
+		case "fiscal_year_start_month":
+			s.FiscalYearStartMonth, err = strconv.Atoi(r.Value)
+		}
+		if err != nil {
+			return fmt.Errorf("setting %s: %w", r.Key, err)
+		}
+	}
+
+	loc, err := s.validate()
+	if err != nil {
+		return err
+	}
+
+	settingsMu.Lock()
+	settings, location = s, loc
+	settingsMu.Unlock()
+	return nil
+}
+
+// CurrentSettings are the settings in use
+func CurrentSettings() Settings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return settings
+}
+
+// UpdateSettings changes the given settings and stores them
+func UpdateSettings(req UpdateSettingsRequest) (Settings, error) {
+	s := CurrentSettings()
+	if req.Timezone != nil {
+		s.Timezone = *req.Timezone
+	}
+	if req.WeekStart != nil {
+		s.WeekStart = *req.WeekStart
+	}
+	if req.MonthStartDay != nil {
+		s.MonthStartDay = *req.MonthStartDay
+	}
+	if req.FiscalYearStartMonth != nil {
+		s.FiscalYearStartMonth = *req.FiscalYearStartMonth
+	}
+
+	loc, err := s.validate()
+	if err != nil {
+		return s, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return s, err
+	}
+	defer tx.Rollback()
+
+	for key, value := range map[string]string{
+		"timezone":                s.Timezone,
+		"week_start":              strconv.Itoa(s.WeekStart),
+		"month_start_day":         strconv.Itoa(s.MonthStartDay),
+		"fiscal_year_start_month": strconv.Itoa(s.FiscalYearStartMonth),
+	} {
+		_, err := tx.Exec(`
+			INSERT INTO settings (key, value) VALUES (?, ?)
+			ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+		`, key, value)
+		if err != nil {
+			return s, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return s, err
+	}
+
+	settingsMu.Lock()
+	settings, location = s, loc
+	settingsMu.Unlock()
+
+	log.Printf("[DB][OK] update_settings(timezone=%s, week_start=%d, month_start_day=%d, fiscal_year_start_month=%d)\n",
+		s.Timezone, s.WeekStart, s.MonthStartDay, s.FiscalYearStartMonth)
+	return s, nil
+}
+
+// Today is the current date in the user's timezone. Like every date the
+// server parses, it is midnight UTC of that day.
+func Today() time.Time {
+	settingsMu.RLock()
+	now := time.Now().In(location)
+	settingsMu.RUnlock()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// ParseDate validates a transaction date and returns it as YYYY-MM-DD. ISO
+// calendar dates are taken as they are; timestamps, as browsers send them,
+// become the date they fall on in the user's timezone.
+func ParseDate(s string) (string, error) {
+	if s == "" {
+		return "", BadRequest("date is required")
+	}
+	if d, err := time.Parse(DateLayout, s); err == nil {
+		return d.Format(DateLayout), nil
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		settingsMu.RLock()
+		defer settingsMu.RUnlock()
+		return ts.In(location).Format(DateLayout), nil
+	}
+	return "", BadRequest("date %q is not a valid YYYY-MM-DD date", s)
+}
+
+// WeekStart is the first day of the week t is in
+func WeekStart(t time.Time) time.Time {
+	start := CurrentSettings().WeekStart
+	return t.AddDate(0, 0, -((int(t.Weekday()) - start + 7) % 7))
+}
+
+// MonthStart is the first day of the month period t is in
+func MonthStart(t time.Time) time.Time {
+	day := CurrentSettings().MonthStartDay
+	start := time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, time.UTC)
+	if t.Day() < day {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// FiscalYearStart is the first day of the fiscal year t is in
+func FiscalYearStart(t time.Time) time.Time {
+	month := time.Month(CurrentSettings().FiscalYearStartMonth)
+	start := time.Date(t.Year(), month, 1, 0, 0, 0, 0, time.UTC)
+	if t.Month() < month {
+		start = start.AddDate(-1, 0, 0)
+	}
+	return start
+}
+
+// QuarterStart is the first day of the fiscal quarter t is in
+func QuarterStart(t time.Time) time.Time {
+	fy := FiscalYearStart(t)
+	months := (int(t.Month()) - int(fy.Month()) + 12) % 12
+	return fy.AddDate(0, months/3*3, 0)
+}
+
+// FiscalYearLabel names the fiscal year starting at start. A fiscal year
+// that isn't the calendar year is named after both years it spans.
+func FiscalYearLabel(start time.Time) string {
+	if start.Month() == time.January {
+		return fmt.Sprintf("FY%d", start.Year())
+	}
+	return fmt.Sprintf("FY%d-%02d", start.Year(), (start.Year()+1)%100)
+}
+
+// Timestamp is a created_at or updated_at value. SQLite keeps them as UTC
+// text; they are served as RFC 3339.
+type Timestamp struct {
+	time.Time
+}
+
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	DateLayout,
+}
+
+// Scan implements sql.Scanner
+func (t *Timestamp) Scan(v any) error {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v.UTC()
+		return nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("can't scan %T into a timestamp", v)
+	}
+
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed.UTC()
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid timestamp %q", s)
+}
+
+// Value implements driver.Valuer, in the format CURRENT_TIMESTAMP writes
+func (t Timestamp) Value() (driver.Value, error) {
+	return t.UTC().Format("2006-01-02 15:04:05"), nil
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.UTC().Format(time.RFC3339))
+}
+
+// NormalizeTransactionDates rewrites every transaction date stored as a
+// timestamp, from before dates were validated, as the date it falls on in
+// the user's timezone. Returns how many were rewritten and the IDs of those
+// that aren't dates at all.
+func NormalizeTransactionDates() (int64, []int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	var rows []struct {
+		ID   int64  `db:"id"`
+		Date string `db:"date"`
+	}
+	err = tx.Select(&rows, `
+		SELECT id, date FROM transactions
+		WHERE date NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]'
+	`)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var n int64
+	invalid := []int64{}
+	for _, r := range rows {
+		date, err := ParseDate(r.Date)
+		if err != nil {
+			invalid = append(invalid, r.ID)
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE transactions SET date = ? WHERE id = ?`, date, r.ID); err != nil {
+			return 0, nil, err
+		}
+		n++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+
+	log.Printf("[DB][OK] normalize_transaction_dates(timezone=%s) rewritten=%d invalid=%d\n", CurrentSettings().Timezone, n, len(invalid))
+	return n, invalid, nil
+}
+
+// HandleGetSettings handles GET /settings
+func HandleGetSettings(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, CurrentSettings())
+}
+
+// HandleUpdateSettings handles PUT /settings
+func HandleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var req UpdateSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	s, err := UpdateSettings(req)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, s)
+}
+
+// HandleNormalizeDates handles POST /settings/normalize-dates
+func HandleNormalizeDates(w http.ResponseWriter, r *http.Request) {
+	n, invalid, err := NormalizeTransactionDates()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"status":    "OK",
+		"rewritten": n,
+		"invalid":   invalid,
+	})
+}
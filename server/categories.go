@@ -0,0 +1,686 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DeleteStrategy decides what DeleteCategory does with whatever still
+// references the deleted category
+type DeleteStrategy string
+
+const (
+	// DeleteRefuse only deletes categories nothing references: no
+	// subcategories, transactions, schedules, payee defaults or rules
+	DeleteRefuse DeleteStrategy = "refuse"
+	// DeleteMove moves subcategories, transactions and the remaining amount
+	// to the target and drops the deleted category's budgets and goal
+	DeleteMove DeleteStrategy = "move"
+	// DeleteMerge also folds the budgets and goal into the target, like
+	// MergeCategory
+	DeleteMerge DeleteStrategy = "merge"
+)
+
+// CategoryUsage describes what references a live category
+type CategoryUsage struct {
+	ParentID      *int64   `db:"parent_id" json:"parent_id,omitempty"`
+	Amount        *float64 `json:"amount"`
+	Subcategories int      `db:"subcategories" json:"subcategories"`
+	Transactions  int      `db:"transactions" json:"transactions"`
+	Budgets       int      `db:"budgets" json:"budgets"`
+	Schedules     int      `db:"schedules" json:"schedules"`
+	Payees        int      `db:"payees" json:"payees"`
+	Rules         int      `db:"rules" json:"rules"`
+}
+
+// GetCategoryUsage returns sql.ErrNoRows if the category doesn't exist
+func GetCategoryUsage(q sqlx.Queryer, id int64) (*CategoryUsage, error) {
+	var u CategoryUsage
+	err := sqlx.Get(q, &u, `
+		SELECT
+			c.parent_id,
+			c.amount,
+			(SELECT COUNT(*) FROM categories s WHERE s.parent_id = c.id AND s.is_deleted = 0) AS subcategories,
+			(SELECT COUNT(*) FROM transactions t WHERE t.category_id = c.id AND t.is_deleted = 0) AS transactions,
+			(SELECT COUNT(*) FROM budgets b WHERE b.category_id = c.id AND b.is_deleted = 0) AS budgets,
+			(SELECT COUNT(*) FROM schedules s WHERE s.category_id = c.id AND s.is_deleted = 0) AS schedules,
+			(SELECT COUNT(*) FROM payees p WHERE p.default_category_id = c.id AND p.is_deleted = 0) AS payees,
+			(SELECT COUNT(*) FROM rules r WHERE r.set_category_id = c.id AND r.is_deleted = 0) AS rules
+		FROM categories c
+		WHERE c.id = ? AND c.is_deleted = 0
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// IsCategoryDescendant reports whether id sits anywhere below ancestorID
+func IsCategoryDescendant(q sqlx.Queryer, ancestorID, id int64) (bool, error) {
+	var n int
+	err := sqlx.Get(q, &n, `
+		WITH RECURSIVE subtree(id) AS (
+			SELECT id FROM categories WHERE parent_id = ?
+			UNION
+			SELECT c.id FROM categories c JOIN subtree s ON c.parent_id = s.id
+		)
+		SELECT COUNT(*) FROM subtree WHERE id = ?
+	`, ancestorID, id)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// validateMergeTarget checks targetID can take over sourceID's contents.
+// The subcategories move along, so the target can't be one of them. The
+// sign of a transaction's amount depends on whether its category is an
+// income one, so income and spending categories don't merge.
+func validateMergeTarget(q sqlx.Queryer, sourceID, targetID int64) error {
+	if targetID == sourceID {
+		return BadRequest("target category must differ from the source")
+	}
+
+	var isIncome []bool
+	err := sqlx.Select(q, &isIncome, `SELECT is_income FROM categories WHERE id = ? AND is_deleted = 0`, targetID)
+	if err != nil {
+		return err
+	}
+	if len(isIncome) == 0 {
+		return NotFound("target category %d not found", targetID)
+	}
+
+	var sourceIsIncome bool
+	if err := sqlx.Get(q, &sourceIsIncome, `SELECT is_income FROM categories WHERE id = ?`, sourceID); err != nil {
+		return err
+	}
+	if sourceIsIncome != isIncome[0] {
+		return BadRequest("can't merge an income category with a spending category")
+	}
+
+	below, err := IsCategoryDescendant(q, sourceID, targetID)
+	if err != nil {
+		return err
+	}
+	if below {
+		return BadRequest("cannot move a category into its own subcategory")
+	}
+	return nil
+}
+
+// categoryReferences are the columns naming a category that new
+// transactions are built from
+var categoryReferences = []string{"schedules.category_id", "payees.default_category_id", "rules.set_category_id"}
+
+// moveCategoryContents hands sourceID's transactions, remaining amount and
+// the schedules, payees and rules that use it to targetID, and appends its
+// live subcategories after the target's own. With mergeBudgets its budget
+// assignments add up with the target's month by month and its goal moves
+// over unless the target has one; otherwise both are dropped.
+// Deleted transactions move too so restoring them lands on a live category.
+func moveCategoryContents(tx *sqlx.Tx, sourceID, targetID int64, mergeBudgets bool) error {
+	var children []int64
+	err := tx.Select(&children, `
+		SELECT id FROM categories WHERE parent_id = ? AND is_deleted = 0 ORDER BY sort_order, id
+	`, sourceID)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := validateCategoryParent(tx, child, &targetID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE categories
+			SET parent_id = ?,
+			    sort_order = (SELECT COALESCE(MAX(sort_order) + 1, 0) FROM categories WHERE parent_id = ? AND is_deleted = 0),
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, targetID, targetID, child)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE transactions
+		SET category_id = ?,
+		    updated_at = CASE WHEN is_deleted = 0 THEN CURRENT_TIMESTAMP ELSE updated_at END
+		WHERE category_id = ?
+	`, targetID, sourceID)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range categoryReferences {
+		table, column, _ := strings.Cut(ref, ".")
+		_, err = tx.Exec(`UPDATE `+table+` SET `+column+` = ? WHERE `+column+` = ?`, targetID, sourceID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE categories
+		SET amount = COALESCE(amount, 0) + (SELECT amount FROM categories WHERE id = ?),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (SELECT amount FROM categories WHERE id = ?) IS NOT NULL
+	`, sourceID, targetID, sourceID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE categories SET amount = NULL WHERE id = ?`, sourceID)
+	if err != nil {
+		return err
+	}
+
+	if mergeBudgets {
+		_, err = tx.Exec(`
+			INSERT INTO budgets (category_id, month, amount)
+			SELECT ?, month, amount FROM budgets WHERE category_id = ? AND is_deleted = 0
+			ON CONFLICT (category_id, month) DO UPDATE
+			SET amount = CASE WHEN is_deleted = 0 THEN amount + excluded.amount ELSE excluded.amount END,
+			    is_deleted = 0,
+			    updated_at = CURRENT_TIMESTAMP
+		`, targetID, sourceID)
+		if err != nil {
+			return err
+		}
+
+		// A category has at most one goal row, live or not
+		_, err = tx.Exec(`DELETE FROM goals WHERE category_id = ? AND is_deleted = 1`, targetID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE goals SET category_id = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE category_id = ? AND is_deleted = 0
+			  AND NOT EXISTS (SELECT 1 FROM goals WHERE category_id = ?)
+		`, targetID, sourceID, targetID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE goals SET is_deleted = 1, updated_at = CURRENT_TIMESTAMP
+		WHERE category_id = ? AND is_deleted = 0
+	`, sourceID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE budgets SET is_deleted = 1, updated_at = CURRENT_TIMESTAMP
+		WHERE category_id = ? AND is_deleted = 0
+	`, sourceID)
+	return err
+}
+
+// MergeRequest is the body of POST /categories/{id}/merge
+type MergeRequest struct {
+	TargetID int64 `json:"target_id"`
+}
+
+// MergePreview lists what merging SourceID into TargetID moves
+type MergePreview struct {
+	SourceID      int64    `json:"source_id"`
+	TargetID      int64    `json:"target_id"`
+	Transactions  int      `json:"transactions"`
+	Subcategories int      `json:"subcategories"`
+	Budgets       int      `json:"budgets"`
+	Schedules     int      `db:"schedules" json:"schedules"`
+	Payees        int      `db:"payees" json:"payees"`
+	Rules         int      `db:"rules" json:"rules"`
+	Goals         int      `db:"goals" json:"goals"`
+	Amount        *float64 `json:"amount"`
+	TargetAmount  *float64 `json:"target_amount"`
+}
+
+func previewMerge(tx *sqlx.Tx, sourceID, targetID int64) (*MergePreview, error) {
+	usage, err := GetCategoryUsage(tx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMergeTarget(tx, sourceID, targetID); err != nil {
+		return nil, err
+	}
+
+	p := &MergePreview{
+		SourceID:      sourceID,
+		TargetID:      targetID,
+		Transactions:  usage.Transactions,
+		Subcategories: usage.Subcategories,
+		Budgets:       usage.Budgets,
+		Schedules:     usage.Schedules,
+		Payees:        usage.Payees,
+		Rules:         usage.Rules,
+		Amount:        usage.Amount,
+	}
+	if err := tx.Get(&p.TargetAmount, `SELECT amount FROM categories WHERE id = ?`, targetID); err != nil {
+		return nil, err
+	}
+
+	err = tx.Get(&p.Goals, `
+		SELECT COUNT(*) FROM goals WHERE category_id = ? AND is_deleted = 0
+		  AND NOT EXISTS (SELECT 1 FROM goals WHERE category_id = ? AND is_deleted = 0)
+	`, sourceID, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The target's amount after the merge, as moveCategoryContents computes it
+	if p.Amount != nil {
+		total := *p.Amount
+		if p.TargetAmount != nil {
+			total += *p.TargetAmount
+		}
+		p.TargetAmount = &total
+	}
+	return p, nil
+}
+
+// PreviewMergeCategory reports what MergeCategory would move without
+// changing anything
+func PreviewMergeCategory(sourceID, targetID int64) (*MergePreview, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return previewMerge(tx, sourceID, targetID)
+}
+
+// MergeCategory moves all transactions, subcategories, budget assignments,
+// the goal, the remaining amount and whatever refers to sourceID into
+// targetID and soft-deletes sourceID, in one SQL transaction
+func MergeCategory(sourceID, targetID int64) (*MergePreview, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, err := previewMerge(tx, sourceID, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := moveCategoryContents(tx, sourceID, targetID, true); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE categories SET is_deleted = 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[DB][OK] merge_category(source_id=%d, target_id=%d, transactions=%d, subcategories=%d)\n",
+		sourceID, targetID, p.Transactions, p.Subcategories)
+	return p, nil
+}
+
+// HandlePreviewMergeCategory handles GET /categories/{id}/merge?target_id=...
+func HandlePreviewMergeCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	targetID, err := strconv.ParseInt(r.URL.Query().Get("target_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid target_id", http.StatusBadRequest)
+		return
+	}
+
+	p, err := PreviewMergeCategory(id, targetID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, p)
+}
+
+// HandleMergeCategory handles POST /categories/{id}/merge
+func HandleMergeCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	var req MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.TargetID == 0 {
+		http.Error(w, "target_id is required", http.StatusBadRequest)
+		return
+	}
+
+	p, err := MergeCategory(id, req.TargetID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"status": "OK",
+		"merged": p,
+	})
+}
+
+// MaxCategoryDepth is how many levels deep the category tree may nest
+const MaxCategoryDepth = 5
+
+// MoveRequest is the body of PUT /categories/{id}/move. A null or missing
+// parent_id moves the category to the root, a missing position appends it
+// after its new siblings.
+type MoveRequest struct {
+	ParentID *int64 `json:"parent_id"`
+	Position *int   `json:"position,omitempty"`
+}
+
+// categoryDepth is how many levels id sits below the root, counting itself
+func categoryDepth(q sqlx.Queryer, id int64) (int, error) {
+	var depth int
+	err := sqlx.Get(q, &depth, `
+		WITH RECURSIVE ancestors(id, parent_id, depth) AS (
+			SELECT id, parent_id, 1 FROM categories WHERE id = ?
+			UNION ALL
+			SELECT c.id, c.parent_id, a.depth + 1
+			FROM categories c JOIN ancestors a ON c.id = a.parent_id
+			WHERE a.depth <= ?
+		)
+		SELECT MAX(depth) FROM ancestors
+	`, id, MaxCategoryDepth)
+	return depth, err
+}
+
+// categoryHeight is how many levels the live subtree rooted at id spans,
+// counting itself
+func categoryHeight(q sqlx.Queryer, id int64) (int, error) {
+	var height int
+	err := sqlx.Get(q, &height, `
+		WITH RECURSIVE subtree(id, depth) AS (
+			SELECT id, 1 FROM categories WHERE id = ?
+			UNION ALL
+			SELECT c.id, s.depth + 1
+			FROM categories c JOIN subtree s ON c.parent_id = s.id
+			WHERE c.is_deleted = 0 AND s.depth <= ?
+		)
+		SELECT MAX(depth) FROM subtree
+	`, id, MaxCategoryDepth)
+	return height, err
+}
+
+// validateCategoryParent checks id can hang below parentID without creating
+// a cycle or nesting deeper than MaxCategoryDepth
+func validateCategoryParent(q sqlx.Queryer, id int64, parentID *int64) error {
+	height, err := categoryHeight(q, id)
+	if err != nil {
+		return err
+	}
+	if parentID == nil {
+		if height > MaxCategoryDepth {
+			return BadRequest("category tree would be deeper than %d levels", MaxCategoryDepth)
+		}
+		return nil
+	}
+
+	if *parentID == id {
+		return BadRequest("a category can't be its own parent")
+	}
+
+	var alive int
+	if err := sqlx.Get(q, &alive, `SELECT COUNT(*) FROM categories WHERE id = ? AND is_deleted = 0`, *parentID); err != nil {
+		return err
+	}
+	if alive == 0 {
+		return NotFound("parent category %d not found", *parentID)
+	}
+
+	below, err := IsCategoryDescendant(q, id, *parentID)
+	if err != nil {
+		return err
+	}
+	if below {
+		return BadRequest("cannot move a category into its own subcategory")
+	}
+
+	depth, err := categoryDepth(q, *parentID)
+	if err != nil {
+		return err
+	}
+	if depth+height > MaxCategoryDepth {
+		return BadRequest("category tree would be deeper than %d levels", MaxCategoryDepth)
+	}
+	return nil
+}
+
+// MoveCategory re-parents a category and places it at position among its new
+// siblings, closing the gap it leaves behind. A nil parentID moves it to the
+// root and a nil position appends it.
+func MoveCategory(id int64, parentID *int64, position *int) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	pos, err := moveCategory(tx, id, parentID, position)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("[DB][OK] move_category(id=%d, parent_id=%v, position=%d)\n", id, parentID, pos)
+	return nil
+}
+
+// moveCategory is MoveCategory within tx. Returns the position the category
+// ended up at.
+func moveCategory(tx *sqlx.Tx, id int64, parentID *int64, position *int) (int, error) {
+	var c Category
+	if err := tx.Get(&c, `SELECT * FROM categories WHERE id = ? AND is_deleted = 0`, id); err != nil {
+		return 0, err
+	}
+
+	if err := validateCategoryParent(tx, id, parentID); err != nil {
+		return 0, err
+	}
+
+	_, err := tx.Exec(`
+		UPDATE categories
+		SET sort_order = sort_order - 1
+		WHERE is_deleted = 0 AND parent_id IS ? AND sort_order > ? AND id != ?
+	`, c.ParentID, c.SortOrder, id)
+	if err != nil {
+		return 0, err
+	}
+
+	var siblings int
+	err = tx.Get(&siblings, `
+		SELECT COUNT(*) FROM categories
+		WHERE is_deleted = 0 AND parent_id IS ? AND id != ?
+	`, parentID, id)
+	if err != nil {
+		return 0, err
+	}
+
+	pos := siblings
+	if position != nil && *position >= 0 && *position < siblings {
+		pos = *position
+	}
+
+	_, err = tx.Exec(`
+		UPDATE categories
+		SET sort_order = sort_order + 1
+		WHERE is_deleted = 0 AND parent_id IS ? AND sort_order >= ? AND id != ?
+	`, parentID, pos, id)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE categories
+		SET parent_id = ?, sort_order = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, parentID, pos, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return pos, nil
+}
+
+// HandleMoveCategory handles PUT /categories/{id}/move
+func HandleMoveCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	var req MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := MoveCategory(id, req.ParentID, req.Position); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
+
+// BulkReorderRequest is the body of PUT /categories/reorder. IDs lists every
+// live child of ParentID (null for the root) in the wanted order.
+type BulkReorderRequest struct {
+	ParentID *int64  `json:"parent_id"`
+	IDs      []int64 `json:"ids"`
+}
+
+// ReorderCategories sets the order of parentID's children to ids, which must
+// name each of them exactly once
+func ReorderCategories(parentID *int64, ids []int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var siblings []int64
+	err = tx.Select(&siblings, `SELECT id FROM categories WHERE is_deleted = 0 AND parent_id IS ?`, parentID)
+	if err != nil {
+		return err
+	}
+
+	expected := make(map[int64]bool, len(siblings))
+	for _, id := range siblings {
+		expected[id] = true
+	}
+	if len(ids) != len(siblings) {
+		return BadRequest("expected %d category ids, got %d", len(siblings), len(ids))
+	}
+	for _, id := range ids {
+		if !expected[id] {
+			return BadRequest("category %d is not a child of parent_id=%v or is listed twice", id, parentID)
+		}
+		delete(expected, id)
+	}
+
+	for i, id := range ids {
+		_, err = tx.Exec(`UPDATE categories SET sort_order = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, i, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("[DB][OK] reorder_categories(parent_id=%v, ids=%v)\n", parentID, ids)
+	return nil
+}
+
+// RepairCategoryOrder renumbers every group of siblings to 0..n-1, keeping
+// their current order and breaking ties by id, so gaps and duplicates left
+// by older versions disappear
+func RepairCategoryOrder() (int64, error) {
+	res, err := db.Exec(`
+		UPDATE categories
+		SET sort_order = r.pos
+		FROM (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY parent_id ORDER BY sort_order, id) - 1 AS pos
+			FROM categories
+			WHERE is_deleted = 0
+		) AS r
+		WHERE r.id = categories.id AND categories.sort_order != r.pos
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("[DB][OK] repair_category_order() renumbered=%d\n", n)
+	return n, nil
+}
+
+// HandleBulkReorderCategories handles PUT /categories/reorder
+func HandleBulkReorderCategories(w http.ResponseWriter, r *http.Request) {
+	var req BulkReorderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := ReorderCategories(req.ParentID, req.IDs); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
+
+// HandleRepairCategoryOrder handles POST /categories/reorder/repair
+func HandleRepairCategoryOrder(w http.ResponseWriter, r *http.Request) {
+	n, err := RepairCategoryOrder()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"status":     "OK",
+		"renumbered": n,
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDeleteCategoryRefuse(t *testing.T) {
+	tests := []struct {
+		name string
+		// use makes something reference category id
+		use      func(t *testing.T, l ledger, id int64)
+		conflict bool
+	}{
+		{"unused", func(t *testing.T, l ledger, id int64) {}, false},
+		{"subcategory", func(t *testing.T, l ledger, id int64) {
+			mustExec(t, `INSERT INTO categories (name, parent_id) VALUES ('Child', ?)`, id)
+		}, true},
+		{"transaction", func(t *testing.T, l ledger, id int64) {
+			if _, err := CreateTransaction(l.account, &id, nil, nil, 10, "2026-01-15"); err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+		{"schedule", func(t *testing.T, l ledger, id int64) {
+			mustExec(t, `INSERT INTO schedules (account_id, category_id, amount, frequency, start_date) VALUES (?, ?, 10, 'monthly', '2026-01-01')`, l.account, id)
+		}, true},
+		{"payee default", func(t *testing.T, l ledger, id int64) {
+			mustExec(t, `INSERT INTO payees (name, normalized, default_category_id) VALUES ('Shop', 'shop', ?)`, id)
+		}, true},
+		{"rule", func(t *testing.T, l ledger, id int64) {
+			mustExec(t, `INSERT INTO rules (name, set_category_id) VALUES ('Shop', ?)`, id)
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLedger(t)
+			id := mustExec(t, `INSERT INTO categories (name) VALUES ('Old')`)
+			tt.use(t, l, id)
+
+			err := DeleteCategory(id, DeleteRefuse, nil)
+			var httpErr *HTTPError
+			if tt.conflict {
+				if !errors.As(err, &httpErr) || httpErr.Status != 409 {
+					t.Fatalf("err = %v, want a conflict", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			var deleted int
+			if err := db.Get(&deleted, `SELECT is_deleted FROM categories WHERE id = ?`, id); err != nil {
+				t.Fatal(err)
+			}
+			if (deleted == 1) == tt.conflict {
+				t.Errorf("is_deleted = %d", deleted)
+			}
+		})
+	}
+}
+
+func TestMergeCategoryKinds(t *testing.T) {
+	tests := []struct {
+		name             string
+		source, target   func(l ledger) int64
+		wantBadRequest   bool
+		wantTargetAmount float64
+	}{
+		{"spending into spending", func(l ledger) int64 { return l.food }, func(l ledger) int64 { return l.transport }, false, 200},
+		{"spending into income", func(l ledger) int64 { return l.food }, func(l ledger) int64 { return l.salary }, true, 100},
+		{"income into spending", func(l ledger) int64 { return l.salary }, func(l ledger) int64 { return l.food }, true, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLedger(t)
+			source, target := tt.source(l), tt.target(l)
+
+			_, err := MergeCategory(source, target)
+			var httpErr *HTTPError
+			if tt.wantBadRequest {
+				if !errors.As(err, &httpErr) || httpErr.Status != 400 {
+					t.Fatalf("err = %v, want a bad request", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := loadBalances(t).Categories[target]; got != tt.wantTargetAmount {
+				t.Errorf("target amount = %v, want %v", got, tt.wantTargetAmount)
+			}
+		})
+	}
+}
+
+func TestUpdateCategoryMoveIsAtomic(t *testing.T) {
+	l := newLedger(t)
+
+	// Renaming to an existing name fails after the move was checked
+	if err := UpdateCategory(l.transport, "Food", nil, &l.food, nil); err == nil {
+		t.Fatal("renamed a category to an existing name")
+	}
+	var parentID *int64
+	if err := db.Get(&parentID, `SELECT parent_id FROM categories WHERE id = ?`, l.transport); err != nil {
+		t.Fatal(err)
+	}
+	if parentID != nil {
+		t.Errorf("parent_id = %d, want the move rolled back", *parentID)
+	}
+
+	if err := UpdateCategory(l.transport, "Travel", nil, &l.food, nil); err != nil {
+		t.Fatal(err)
+	}
+	var c Category
+	if err := db.Get(&c, `SELECT * FROM categories WHERE id = ?`, l.transport); err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "Travel" || !sameID(c.ParentID, &l.food) {
+		t.Errorf("category = %s under %v, want Travel under %d", c.Name, c.ParentID, l.food)
+	}
+}
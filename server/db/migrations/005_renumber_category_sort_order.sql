@@ -0,0 +1,13 @@
+BEGIN TRANSACTION;
+
+/* Sort order is now scoped to siblings: renumber every parent's children 0..n-1 */
+UPDATE categories
+SET sort_order = r.pos
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY parent_id ORDER BY sort_order, id) - 1 AS pos
+    FROM categories
+    WHERE is_deleted = 0
+) AS r
+WHERE r.id = categories.id;
+
+COMMIT;
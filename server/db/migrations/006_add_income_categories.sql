@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+/* Income categories default transactions to inflows and feed the cash-flow report */
+ALTER TABLE categories ADD COLUMN is_income INTEGER NOT NULL DEFAULT 0;
+
+COMMIT;
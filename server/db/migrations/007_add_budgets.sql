@@ -0,0 +1,17 @@
+BEGIN TRANSACTION;
+
+/* Monthly budget assignments per category, month is YYYY-MM */
+CREATE TABLE IF NOT EXISTS budgets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    category_id INTEGER NOT NULL,
+    month TEXT NOT NULL,
+    amount REAL NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    is_deleted INTEGER NOT NULL DEFAULT 0,
+
+    UNIQUE (category_id, month),
+    FOREIGN KEY (category_id) REFERENCES categories(id)
+);
+
+COMMIT;
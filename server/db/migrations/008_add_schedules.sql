@@ -0,0 +1,47 @@
+BEGIN TRANSACTION;
+
+/* Recurring transactions. frequency is daily, weekly, monthly or yearly;
+   monthly schedules with week_of_month (1-4, -1 for last) and weekday
+   (0 = Sunday) repeat on the nth weekday instead of start_date's day */
+CREATE TABLE IF NOT EXISTS schedules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account_id INTEGER NOT NULL,
+    category_id INTEGER,
+    payee TEXT,
+    memo TEXT,
+    amount REAL NOT NULL,
+    frequency TEXT NOT NULL,
+    interval INTEGER NOT NULL DEFAULT 1,
+    week_of_month INTEGER,
+    weekday INTEGER,
+    start_date TEXT NOT NULL,
+    end_date TEXT,
+    max_count INTEGER,
+    occurrences INTEGER NOT NULL DEFAULT 0,
+    next_date TEXT,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    is_deleted INTEGER NOT NULL DEFAULT 0,
+
+    FOREIGN KEY (account_id) REFERENCES accounts(id),
+    FOREIGN KEY (category_id) REFERENCES categories(id)
+);
+
+/* Skipped or postponed single occurrences. date is the occurrence's original
+   date, new_date where a postponed one moved to, transaction_id what it
+   posted once due */
+CREATE TABLE IF NOT EXISTS schedule_exceptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    schedule_id INTEGER NOT NULL,
+    date TEXT NOT NULL,
+    action TEXT NOT NULL,
+    new_date TEXT,
+    transaction_id INTEGER,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (schedule_id, date),
+    FOREIGN KEY (schedule_id) REFERENCES schedules(id),
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id)
+);
+
+COMMIT;
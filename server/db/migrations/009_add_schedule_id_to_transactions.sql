@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+/* Transactions posted by a recurring schedule, so forecasts can tell them
+   apart from ad-hoc spending */
+ALTER TABLE transactions ADD COLUMN schedule_id INTEGER REFERENCES schedules(id);
+
+COMMIT;
@@ -0,0 +1,32 @@
+BEGIN TRANSACTION;
+
+/* Payees. normalized is the matching key (see NormalizePayee), name the
+   canonical spelling shown on transactions */
+CREATE TABLE IF NOT EXISTS payees (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    normalized TEXT NOT NULL UNIQUE,
+    default_category_id INTEGER,
+    use_count INTEGER NOT NULL DEFAULT 0,
+    last_used_at TEXT,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    is_deleted INTEGER NOT NULL DEFAULT 0,
+
+    FOREIGN KEY (default_category_id) REFERENCES categories(id)
+);
+
+/* Other spellings that resolve to a payee, e.g. "AMAZON MKTPLACE" */
+CREATE TABLE IF NOT EXISTS payee_aliases (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    payee_id INTEGER NOT NULL,
+    alias TEXT NOT NULL,
+    normalized TEXT NOT NULL UNIQUE,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (payee_id) REFERENCES payees(id)
+);
+
+ALTER TABLE transactions ADD COLUMN payee_id INTEGER REFERENCES payees(id);
+
+COMMIT;
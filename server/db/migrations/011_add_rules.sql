@@ -0,0 +1,27 @@
+BEGIN TRANSACTION;
+
+/* Auto-categorization rules. Every non-NULL condition must match; rules are
+   tried by priority, highest first, and the first matching rule to set a
+   field wins it */
+CREATE TABLE IF NOT EXISTS rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    priority INTEGER NOT NULL DEFAULT 0,
+    payee_contains TEXT,
+    payee_regex TEXT,
+    memo_contains TEXT,
+    min_amount REAL,
+    max_amount REAL,
+    account_id INTEGER,
+    set_category_id INTEGER,
+    set_payee TEXT,
+    set_memo TEXT,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    is_deleted INTEGER NOT NULL DEFAULT 0,
+
+    FOREIGN KEY (account_id) REFERENCES accounts(id),
+    FOREIGN KEY (set_category_id) REFERENCES categories(id)
+);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+/* Whether the transaction has shown up on the bank statement */
+ALTER TABLE transactions ADD COLUMN cleared INTEGER NOT NULL DEFAULT 0;
+
+COMMIT;
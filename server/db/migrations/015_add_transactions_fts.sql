@@ -0,0 +1,68 @@
+BEGIN TRANSACTION;
+
+/* Full-text index of live transactions, rowid = transactions.id. Needs
+   SQLite with FTS5: build the server with -tags sqlite_fts5 to serve search.
+   A server built without it drops these triggers and turns search off, see
+   SetupSearch */
+CREATE VIRTUAL TABLE IF NOT EXISTS transactions_fts USING fts5(
+    payee,
+    memo,
+    category,
+    account,
+    tokenize = 'unicode61 remove_diacritics 2',
+    prefix = '2 3'
+);
+
+INSERT INTO transactions_fts (rowid, payee, memo, category, account)
+SELECT t.id, t.payee, t.memo, c.name, a.name
+FROM transactions t
+LEFT JOIN categories c ON c.id = t.category_id
+LEFT JOIN accounts a ON a.id = t.account_id
+WHERE t.is_deleted = 0;
+
+CREATE TRIGGER IF NOT EXISTS transactions_fts_insert AFTER INSERT ON transactions
+WHEN new.is_deleted = 0
+BEGIN
+    INSERT INTO transactions_fts (rowid, payee, memo, category, account)
+    VALUES (
+        new.id,
+        new.payee,
+        new.memo,
+        (SELECT name FROM categories WHERE id = new.category_id),
+        (SELECT name FROM accounts WHERE id = new.account_id)
+    );
+END;
+
+/* Covers soft-deletes and restores too: deleted rows aren't re-indexed */
+CREATE TRIGGER IF NOT EXISTS transactions_fts_update AFTER UPDATE ON transactions
+BEGIN
+    DELETE FROM transactions_fts WHERE rowid = old.id;
+    INSERT INTO transactions_fts (rowid, payee, memo, category, account)
+    SELECT
+        new.id,
+        new.payee,
+        new.memo,
+        (SELECT name FROM categories WHERE id = new.category_id),
+        (SELECT name FROM accounts WHERE id = new.account_id)
+    WHERE new.is_deleted = 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS transactions_fts_delete AFTER DELETE ON transactions
+BEGIN
+    DELETE FROM transactions_fts WHERE rowid = old.id;
+END;
+
+/* Renamed categories and accounts re-index their transactions */
+CREATE TRIGGER IF NOT EXISTS transactions_fts_category_rename AFTER UPDATE OF name ON categories
+BEGIN
+    UPDATE transactions_fts SET category = new.name
+    WHERE rowid IN (SELECT id FROM transactions WHERE category_id = new.id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS transactions_fts_account_rename AFTER UPDATE OF name ON accounts
+BEGIN
+    UPDATE transactions_fts SET account = new.name
+    WHERE rowid IN (SELECT id FROM transactions WHERE account_id = new.id);
+END;
+
+COMMIT;
@@ -0,0 +1,14 @@
+BEGIN TRANSACTION;
+
+/* Named transaction listings. query holds the GET /transactions query
+   parameters, e.g. "category_id=4&cleared=false&range=this_quarter" */
+CREATE TABLE IF NOT EXISTS saved_filters (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    query TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    is_deleted INTEGER NOT NULL DEFAULT 0
+);
+
+COMMIT;
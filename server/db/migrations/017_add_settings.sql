@@ -0,0 +1,12 @@
+BEGIN TRANSACTION;
+
+/* User preferences as key/value pairs: timezone (IANA name), week_start
+   (0 = Sunday), month_start_day (1-28) and fiscal_year_start_month (1-12).
+   Missing keys use the server defaults. */
+CREATE TABLE IF NOT EXISTS settings (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMIT;
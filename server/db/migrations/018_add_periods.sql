@@ -0,0 +1,16 @@
+BEGIN TRANSACTION;
+
+/* Custom named reporting periods, e.g. "Tax year 2025" from 2025-04-06 to
+   2026-04-05. Reports, budgets and transaction filters accept the name
+   wherever they accept a relative range. */
+CREATE TABLE IF NOT EXISTS periods (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    start_date TEXT NOT NULL,
+    end_date TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    is_deleted INTEGER NOT NULL DEFAULT 0
+);
+
+COMMIT;
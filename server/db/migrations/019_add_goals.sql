@@ -0,0 +1,19 @@
+BEGIN TRANSACTION;
+
+/* One goal per category. type is target_balance (target_amount available
+   by target_date), monthly_contribution (target_amount funded every month)
+   or spending (target_amount needed every month to cover recurring spending) */
+CREATE TABLE IF NOT EXISTS goals (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    category_id INTEGER NOT NULL UNIQUE,
+    type TEXT NOT NULL,
+    target_amount REAL NOT NULL,
+    target_date TEXT,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    is_deleted INTEGER NOT NULL DEFAULT 0,
+
+    FOREIGN KEY (category_id) REFERENCES categories(id)
+);
+
+COMMIT;
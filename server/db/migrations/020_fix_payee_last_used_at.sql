@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+/* Payee rebuilds stored last_used_at as a bare date; make it a timestamp
+   like CURRENT_TIMESTAMP so the recency ranking compares like with like */
+UPDATE payees
+SET last_used_at = datetime(last_used_at)
+WHERE last_used_at IS NOT NULL AND length(last_used_at) = 10;
+
+COMMIT;
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultDuplicateDays is how many days apart duplicates may be dated
+	DefaultDuplicateDays = 3
+	// DefaultAmountTolerance is how far apart duplicate amounts may be
+	DefaultAmountTolerance = 0.01
+	// DefaultPayeeSimilarity is the least payee similarity of duplicates
+	DefaultPayeeSimilarity = 0.6
+)
+
+// DuplicateOptions tune what counts as a likely duplicate
+type DuplicateOptions struct {
+	Days            int
+	AmountTolerance float64
+	PayeeSimilarity float64
+}
+
+// DuplicatePair is two transactions of the same account that look like the
+// same real-world transaction. Score, between 0 and 1, weighs payee
+// similarity most, then how close the dates and amounts are.
+type DuplicatePair struct {
+	First           TransactionWithRelations `json:"first"`
+	Second          TransactionWithRelations `json:"second"`
+	DaysApart       int                      `json:"days_apart"`
+	PayeeSimilarity float64                  `json:"payee_similarity"`
+	Score           float64                  `json:"score"`
+}
+
+type MergeTransactionRequest struct {
+	DuplicateID int64 `json:"duplicate_id"`
+}
+
+// levenshtein is the edit distance between a and b
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// PayeeSimilarity compares two payees after normalization: 1 when they are
+// the same payee, down to 0 when they share nothing. A missing payee is
+// neither similar nor dissimilar, even to another missing one.
+func PayeeSimilarity(a, b *string, aID, bID *int64) float64 {
+	if aID != nil && sameID(aID, bID) {
+		return 1
+	}
+
+	na, nb := NormalizePayee(StringValue(a)), NormalizePayee(StringValue(b))
+	switch {
+	case na == "" || nb == "":
+		return 0.5
+	case strings.HasPrefix(na, nb) || strings.HasPrefix(nb, na):
+		// "amazon" and "amazon marketplace"
+		return math.Max(0.8, 1-float64(levenshtein([]rune(na), []rune(nb)))/float64(max(len(na), len(nb))))
+	}
+
+	ra, rb := []rune(na), []rune(nb)
+	return 1 - float64(levenshtein(ra, rb))/float64(max(len(ra), len(rb)))
+}
+
+// FindDuplicates lists the likely duplicate pairs among live transactions,
+// best candidates first. Transfers are left out.
+func FindDuplicates(opts DuplicateOptions) ([]DuplicatePair, error) {
+	var pairs []struct {
+		FirstID   int64   `db:"first_id"`
+		SecondID  int64   `db:"second_id"`
+		DaysApart float64 `db:"days_apart"`
+	}
+	err := db.Select(&pairs, `
+		SELECT a.id AS first_id, b.id AS second_id,
+			ABS(julianday(date(a.date)) - julianday(date(b.date))) AS days_apart
+		FROM transactions a
+		JOIN transactions b
+		  ON b.account_id = a.account_id
+		 AND b.id > a.id
+		 AND ABS(b.amount - a.amount) <= ?
+		 AND ABS(julianday(date(a.date)) - julianday(date(b.date))) <= ?
+		WHERE a.is_deleted = 0 AND b.is_deleted = 0
+		  AND a.transfer_account_id IS NULL AND b.transfer_account_id IS NULL
+	`, opts.AmountTolerance+1e-9, opts.Days)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []DuplicatePair{}
+	if len(pairs) == 0 {
+		return result, nil
+	}
+
+	ids := make([]int64, 0, len(pairs)*2)
+	for _, p := range pairs {
+		ids = append(ids, p.FirstID, p.SecondID)
+	}
+	candidates, err := ListTransactions(TransactionFilter{IDs: ids})
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int64]TransactionWithRelations{}
+	for _, t := range candidates {
+		byID[t.ID] = t
+	}
+
+	for _, p := range pairs {
+		a, okA := byID[p.FirstID]
+		b, okB := byID[p.SecondID]
+		if !okA || !okB {
+			continue
+		}
+
+		similarity := PayeeSimilarity(a.Payee, b.Payee, a.PayeeID, b.PayeeID)
+		if similarity < opts.PayeeSimilarity {
+			continue
+		}
+
+		dateCloseness := 1 - p.DaysApart/float64(opts.Days+1)
+		amountCloseness := 1.0
+		if opts.AmountTolerance > 0 {
+			amountCloseness = 1 - math.Abs(a.Amount-b.Amount)/(opts.AmountTolerance*2)
+		}
+
+		result = append(result, DuplicatePair{
+			First:           a,
+			Second:          b,
+			DaysApart:       int(p.DaysApart),
+			PayeeSimilarity: similarity,
+			Score:           0.5*similarity + 0.3*dateCloseness + 0.2*amountCloseness,
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Score > result[j].Score
+	})
+	return result, nil
+}
+
+// MergeTransactions keeps id and folds duplicateID into it: the memos are
+// combined, the duplicate's tags and attachments move over, and the
+// duplicate goes to the trash with its balance and category effects reversed.
+func MergeTransactions(id, duplicateID int64) error {
+	if id == duplicateID {
+		return BadRequest("a transaction can't be merged into itself")
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var keep, dup Transaction
+	if err := tx.Get(&keep, `SELECT * FROM transactions WHERE id = ? AND is_deleted = 0`, id); err != nil {
+		return err
+	}
+	if err := tx.Get(&dup, `SELECT * FROM transactions WHERE id = ? AND is_deleted = 0`, duplicateID); err != nil {
+		return err
+	}
+
+	memo := StringValue(keep.Memo)
+	if other := StringValue(dup.Memo); other != "" && !strings.Contains(memo, other) {
+		if memo != "" {
+			memo += " / "
+		}
+		memo += other
+	}
+
+	_, err = tx.Exec(`
+		UPDATE transactions
+		SET memo = NULLIF(?, ''),
+			category_id = COALESCE(category_id, ?),
+			payee = COALESCE(payee, ?),
+			payee_id = COALESCE(payee_id, ?),
+			cleared = MAX(cleared, ?),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, memo, dup.CategoryID, dup.Payee, dup.PayeeID, dup.Cleared, id)
+	if err != nil {
+		return err
+	}
+
+	// An uncategorized keeper picked up the duplicate's category above
+	if keep.CategoryID == nil && dup.CategoryID != nil {
+		keep.CategoryID = dup.CategoryID
+		_, err = tx.Exec(`UPDATE categories SET amount = amount - ? WHERE id = ?`, keep.Amount, *keep.CategoryID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO transaction_tags (transaction_id, tag_id)
+		SELECT ?, tag_id FROM transaction_tags WHERE transaction_id = ?
+	`, id, duplicateID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE attachments SET transaction_id = ?, updated_at = CURRENT_TIMESTAMP WHERE transaction_id = ?`, id, duplicateID)
+	if err != nil {
+		return err
+	}
+
+	if err := applyTransactionEffect(tx, &dup, -1); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE transactions SET is_deleted = 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, duplicateID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("[DB][OK] merge_transactions(id=%d, duplicate_id=%d)\n", id, duplicateID)
+	return nil
+}
+
+// HandleFindDuplicates handles
+// GET /transactions/duplicates?days=N&amount_tolerance=X&payee_similarity=0..1
+func HandleFindDuplicates(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := DuplicateOptions{
+		Days:            DefaultDuplicateDays,
+		AmountTolerance: DefaultAmountTolerance,
+		PayeeSimilarity: DefaultPayeeSimilarity,
+	}
+
+	var err error
+	if v := q.Get("days"); v != "" {
+		if opts.Days, err = strconv.Atoi(v); err != nil || opts.Days < 0 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("amount_tolerance"); v != "" {
+		if opts.AmountTolerance, err = strconv.ParseFloat(v, 64); err != nil || opts.AmountTolerance < 0 {
+			http.Error(w, "Invalid amount_tolerance", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("payee_similarity"); v != "" {
+		if opts.PayeeSimilarity, err = strconv.ParseFloat(v, 64); err != nil || opts.PayeeSimilarity < 0 || opts.PayeeSimilarity > 1 {
+			http.Error(w, "Invalid payee_similarity", http.StatusBadRequest)
+			return
+		}
+	}
+
+	pairs, err := FindDuplicates(opts)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, pairs)
+}
+
+// HandleMergeTransaction handles POST /transactions/{id}/merge
+func HandleMergeTransaction(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	var req MergeTransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.DuplicateID == 0 {
+		http.Error(w, "duplicate_id is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := MergeTransactions(id, req.DuplicateID); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
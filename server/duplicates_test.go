@@ -0,0 +1,168 @@
+package main
+
+import "testing"
+
+func TestPayeeSimilarity(t *testing.T) {
+	str := func(s string) *string { return &s }
+	id := func(id int64) *int64 { return &id }
+
+	tests := []struct {
+		name     string
+		a, b     *string
+		aID, bID *int64
+		want     float64
+	}{
+		{"same payee", str("Shop"), str("Other"), id(1), id(1), 1},
+		{"same name", str("The Shop"), str("the  shop"), nil, nil, 1},
+		{"prefix", str("Amazon"), str("Amazon Marketplace"), nil, nil, 0.8},
+		{"one missing", str("Shop"), nil, nil, nil, 0.5},
+		{"both missing", nil, str(""), nil, nil, 0.5},
+		{"different", str("abc"), str("xyz"), nil, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PayeeSimilarity(tt.a, tt.b, tt.aID, tt.bID); got != tt.want {
+				t.Errorf("PayeeSimilarity = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeTransactions(t *testing.T) {
+	tests := []struct {
+		name string
+		// keep and dup are the categories of the two transactions
+		keep, dup func(l ledger) *int64
+		// wantCategory is the merged transaction's category
+		wantCategory func(l ledger) *int64
+		want         func(l ledger) map[int64]float64
+	}{
+		{
+			name:         "both categorized",
+			keep:         func(l ledger) *int64 { return &l.food },
+			dup:          func(l ledger) *int64 { return &l.transport },
+			wantCategory: func(l ledger) *int64 { return &l.food },
+			want: func(l ledger) map[int64]float64 {
+				return map[int64]float64{l.food: 60, l.transport: 100}
+			},
+		},
+		{
+			name:         "uncategorized keeper takes the duplicate's category",
+			keep:         func(l ledger) *int64 { return nil },
+			dup:          func(l ledger) *int64 { return &l.transport },
+			wantCategory: func(l ledger) *int64 { return &l.transport },
+			want: func(l ledger) map[int64]float64 {
+				return map[int64]float64{l.food: 100, l.transport: 60}
+			},
+		},
+		{
+			name:         "uncategorized duplicate",
+			keep:         func(l ledger) *int64 { return &l.food },
+			dup:          func(l ledger) *int64 { return nil },
+			wantCategory: func(l ledger) *int64 { return &l.food },
+			want: func(l ledger) map[int64]float64 {
+				return map[int64]float64{l.food: 60, l.transport: 100}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLedger(t)
+
+			memo := "lunch"
+			keepID, err := CreateTransaction(l.account, tt.keep(l), nil, nil, 40, "2026-01-15")
+			if err != nil {
+				t.Fatal(err)
+			}
+			dupID, err := CreateTransaction(l.account, tt.dup(l), nil, &memo, 40.01, "2026-01-16")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := MergeTransactions(keepID, dupID); err != nil {
+				t.Fatal(err)
+			}
+
+			b := loadBalances(t)
+			if got, want := b.Accounts[l.account], 960.0; got != want {
+				t.Errorf("account balance = %v, want %v", got, want)
+			}
+			for category, want := range tt.want(l) {
+				if got := b.Categories[category]; got != want {
+					t.Errorf("category %d amount = %v, want %v", category, got, want)
+				}
+			}
+
+			var kept Transaction
+			if err := db.Get(&kept, `SELECT * FROM transactions WHERE id = ?`, keepID); err != nil {
+				t.Fatal(err)
+			}
+			if !sameID(kept.CategoryID, tt.wantCategory(l)) {
+				t.Errorf("category = %v, want %v", kept.CategoryID, tt.wantCategory(l))
+			}
+			if StringValue(kept.Memo) != memo {
+				t.Errorf("memo = %q, want %q", StringValue(kept.Memo), memo)
+			}
+
+			var deleted int
+			if err := db.Get(&deleted, `SELECT is_deleted FROM transactions WHERE id = ?`, dupID); err != nil {
+				t.Fatal(err)
+			}
+			if deleted != 1 {
+				t.Error("duplicate is not in the trash")
+			}
+		})
+	}
+}
+
+func TestMergeTransactionIntoItself(t *testing.T) {
+	l := newLedger(t)
+
+	id, err := CreateTransaction(l.account, &l.food, nil, nil, 40, "2026-01-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MergeTransactions(id, id); err == nil {
+		t.Fatal("merged a transaction into itself")
+	}
+	if got := loadBalances(t).Categories[l.food]; got != 60 {
+		t.Errorf("food amount = %v, want 60", got)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	l := newLedger(t)
+
+	shop, other := "The Shop", "THE SHOP"
+	for _, tx := range []struct {
+		payee  *string
+		amount float64
+		date   string
+	}{
+		{&shop, 25, "2026-01-10"},
+		{&other, 25, "2026-01-11"},
+		{nil, 40, "2026-01-10"},
+		{nil, 40, "2026-01-10"},
+		{&shop, 25, "2026-02-20"},
+	} {
+		if _, err := CreateTransaction(l.account, &l.food, tx.payee, nil, tx.amount, tx.date); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pairs, err := FindDuplicates(DuplicateOptions{
+		Days:            DefaultDuplicateDays,
+		AmountTolerance: DefaultAmountTolerance,
+		PayeeSimilarity: DefaultPayeeSimilarity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 1 {
+		t.Fatalf("found %d pairs, want 1: %+v", len(pairs), pairs)
+	}
+	if p := pairs[0]; p.First.Date != "2026-01-10" || p.Second.Date != "2026-01-11" || p.PayeeSimilarity != 1 {
+		t.Errorf("pair = %+v", p)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// exportTables are the tables written to the export archive, deleted rows
+// included so that an export is a complete copy
+var exportTables = []string{
+	"accounts",
+	"categories",
+	"transactions",
+	"budgets",
+	"schedules",
+	"schedule_exceptions",
+	"payees",
+	"payee_aliases",
+	"rules",
+	"tags",
+	"transaction_tags",
+	"attachments",
+	"saved_filters",
+	"settings",
+	"periods",
+	"goals",
+}
+
+// exportTable reads every row of table as column/value maps. Attachment data
+// goes into the archive as files instead.
+func exportTable(table string) ([]map[string]any, error) {
+	columns := "*"
+	if table == "attachments" {
+		columns = "id, transaction_id, filename, content_type, size, sha256, created_at, updated_at, is_deleted"
+	}
+
+	rows, err := db.Queryx(fmt.Sprintf("SELECT %s FROM %s ORDER BY rowid", columns, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []map[string]any{}
+	for rows.Next() {
+		row := map[string]any{}
+		if err := rows.MapScan(row); err != nil {
+			return nil, err
+		}
+		for k, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[k] = string(b)
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// WriteExport writes the zip archive of GET /export: one JSON file per table
+// under data/ and every stored attachment under attachments/<id>/
+func WriteExport(w http.ResponseWriter) error {
+	// Read everything before writing so a failure can still be reported
+	data := map[string][]map[string]any{}
+	for _, table := range exportTables {
+		rows, err := exportTable(table)
+		if err != nil {
+			return err
+		}
+		data[table] = rows
+	}
+
+	var ids []int64
+	if err := db.Select(&ids, `SELECT id FROM attachments ORDER BY id`); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(
+		`attachment; filename="expense-tracker-export-%s.zip"`, now.Format(DateLayout)))
+
+	archive := zip.NewWriter(w)
+	create := func(name string) (io.Writer, error) {
+		return archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+	}
+
+	for _, table := range exportTables {
+		f, err := create("data/" + table + ".json")
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(data[table]); err != nil {
+			return err
+		}
+	}
+
+	// One at a time so the whole set of files is never in memory
+	for _, id := range ids {
+		var a Attachment
+		if err := db.Get(&a, `SELECT * FROM attachments WHERE id = ?`, id); err != nil {
+			return err
+		}
+		f, err := create(fmt.Sprintf("attachments/%d/%s", a.ID, a.Filename))
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(a.Data); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("[DB][OK] export(tables=%d, attachments=%d)\n", len(exportTables), len(ids))
+	return nil
+}
+
+// HandleExport handles GET /export
+func HandleExport(w http.ResponseWriter, r *http.Request) {
+	if err := WriteExport(w); err != nil {
+		// Only reaches the client if nothing was written yet
+		WriteError(w, err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SavedFilter is a named transaction listing. Query holds the query
+// parameters of GET /transactions and is parsed again on every run, so
+// relative ranges resolve against the day it runs.
+type SavedFilter struct {
+	ID        int64             `json:"id"`
+	Name      string            `json:"name"`
+	Query     string            `json:"query"`
+	Filter    TransactionFilter `db:"-" json:"filter"`
+	CreatedAt Timestamp         `db:"created_at" json:"created_at"`
+	UpdatedAt Timestamp         `db:"updated_at" json:"updated_at"`
+	IsDeleted int               `db:"is_deleted" json:"is_deleted"`
+}
+
+type SavedFilterRequest struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// parse checks the request and returns its query in canonical form
+func (r SavedFilterRequest) parse() (string, error) {
+	if strings.TrimSpace(r.Name) == "" {
+		return "", BadRequest("name is required")
+	}
+
+	values, err := url.ParseQuery(strings.TrimPrefix(r.Query, "?"))
+	if err != nil {
+		return "", BadRequest("query is not a valid query string")
+	}
+	if _, err := ParseTransactionFilter(values); err != nil {
+		return "", err
+	}
+	return values.Encode(), nil
+}
+
+func (f *SavedFilter) parse() error {
+	values, err := url.ParseQuery(f.Query)
+	if err != nil {
+		return err
+	}
+	f.Filter, err = ParseTransactionFilter(values)
+	return err
+}
+
+// GetSavedFilter loads a live saved filter with its parsed filter
+func GetSavedFilter(id int64) (*SavedFilter, error) {
+	f, err := NewRepository[SavedFilter](db, "saved_filters", "id").GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.parse(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// HandleGetSavedFilters handles GET /filters
+func HandleGetSavedFilters(w http.ResponseWriter, r *http.Request) {
+	filters, err := NewRepository[SavedFilter](db, "saved_filters", "id").List(WithOrderBy("name"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if filters == nil {
+		filters = []SavedFilter{}
+	}
+	for i := range filters {
+		// Saved filters are validated on save; one that no longer parses
+		// (e.g. an unknown range) still lists, with an empty filter
+		filters[i].parse()
+	}
+
+	WriteJSON(w, filters)
+}
+
+// HandleCreateSavedFilter handles POST /filters
+func HandleCreateSavedFilter(w http.ResponseWriter, r *http.Request) {
+	var req SavedFilterRequest
+
+	HandleCreate(
+		w,
+		r,
+		&req,
+		nil,
+		func(r SavedFilterRequest) (int64, error) {
+			query, err := r.parse()
+			if err != nil {
+				return 0, err
+			}
+
+			id, err := NewRepository[SavedFilter](db, "saved_filters", "id").Create(map[string]interface{}{
+				"name":  strings.TrimSpace(r.Name),
+				"query": query,
+			})
+			if err != nil {
+				return 0, err
+			}
+
+			log.Printf("[DB][OK] create_saved_filter(id=%d, name=%s, query=%s)\n", id, r.Name, query)
+			return id, nil
+		},
+	)
+}
+
+// HandleUpdateSavedFilter handles PUT /filters/{id}
+func HandleUpdateSavedFilter(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	var req SavedFilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	query, err := req.parse()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	repo := NewRepository[SavedFilter](db, "saved_filters", "id")
+	if _, err := repo.GetByID(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+	err = repo.Update(id, map[string]interface{}{
+		"name":  strings.TrimSpace(req.Name),
+		"query": query,
+	})
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
+
+// HandleDeleteSavedFilter handles DELETE /filters/{id}
+func HandleDeleteSavedFilter(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	repo := NewRepository[SavedFilter](db, "saved_filters", "id")
+	if _, err := repo.GetByID(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := repo.Delete(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
+
+// HandleRunSavedFilter handles GET /filters/{id}/transactions
+func HandleRunSavedFilter(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	f, err := GetSavedFilter(id)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	txs, err := ListTransactions(f.Filter)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, txs)
+}
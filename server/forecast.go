@@ -0,0 +1,263 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultForecastMonths = 6
+	MinForecastMonths     = 3
+	MaxForecastMonths     = 12
+
+	// DefaultHistoryMonths is how many full past months the spending
+	// averages are taken over
+	DefaultHistoryMonths = 6
+
+	// ForecastConfidence is the coverage of the lower/upper band, and
+	// forecastZ the matching normal quantile
+	ForecastConfidence = 0.9
+	forecastZ          = 1.645
+)
+
+// ForecastPoint is a projected balance at the end of a month. Scheduled is
+// the net of that month's schedules, Estimated the expected unscheduled
+// spending; Lower and Upper bound the balance at ForecastConfidence.
+type ForecastPoint struct {
+	Period    string  `json:"period"`
+	Date      string  `json:"date"`
+	Scheduled float64 `json:"scheduled"`
+	Estimated float64 `json:"estimated"`
+	Balance   float64 `json:"balance"`
+	Lower     float64 `json:"lower"`
+	Upper     float64 `json:"upper"`
+}
+
+// AccountForecast is one account's projection
+type AccountForecast struct {
+	AccountID int64           `json:"account_id"`
+	Name      string          `json:"name"`
+	Balance   float64         `json:"balance"`
+	Series    []ForecastPoint `json:"series"`
+}
+
+// Forecast is the response of GET /forecast
+type Forecast struct {
+	AsOf          string            `json:"as_of"`
+	Months        int               `json:"months"`
+	HistoryMonths int               `json:"history_months"`
+	Confidence    float64           `json:"confidence"`
+	Accounts      []AccountForecast `json:"accounts"`
+	Total         []ForecastPoint   `json:"total"`
+}
+
+// spendingStats is the mean and variance of an account's monthly
+// unscheduled spending, summed over its categories
+type spendingStats struct {
+	Mean     float64
+	Variance float64
+}
+
+// loadSpendingStats averages each account's unscheduled spending per
+// category over the history months before today's month. Months without
+// any spending count as zero. Transfers aren't spending and transactions
+// posted by schedules are forecast from the schedules themselves.
+func loadSpendingStats(today time.Time, historyMonths int) (map[int64]spendingStats, error) {
+	thisMonth := MonthStart(today)
+	from := thisMonth.AddDate(0, -historyMonths, 0)
+	month, _ := BucketExpr("month", "date")
+
+	var rows []struct {
+		AccountID  int64   `db:"account_id"`
+		CategoryID *int64  `db:"category_id"`
+		Month      string  `db:"month"`
+		Amount     float64 `db:"amount"`
+	}
+	err := db.Select(&rows, `
+		SELECT account_id, category_id, `+month+` AS month, SUM(amount) AS amount
+		FROM transactions
+		WHERE is_deleted = 0
+		  AND schedule_id IS NULL
+		  AND transfer_account_id IS NULL
+		  AND date(date) >= ? AND date(date) < ?
+		GROUP BY account_id, category_id, month
+	`, from.Format(DateLayout), thisMonth.Format(DateLayout))
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		account  int64
+		category int64
+	}
+	monthly := map[key]map[string]float64{}
+	for _, r := range rows {
+		k := key{account: r.AccountID}
+		if r.CategoryID != nil {
+			k.category = *r.CategoryID
+		}
+		if monthly[k] == nil {
+			monthly[k] = map[string]float64{}
+		}
+		monthly[k][r.Month] += r.Amount
+	}
+
+	stats := map[int64]spendingStats{}
+	n := float64(historyMonths)
+	for k, months := range monthly {
+		var sum, sumSq float64
+		for m := from; m.Before(thisMonth); m = m.AddDate(0, 1, 0) {
+			v := months[m.Format(MonthLayout)]
+			sum += v
+			sumSq += v * v
+		}
+
+		mean := sum / n
+		variance := 0.0
+		if historyMonths > 1 {
+			variance = math.Max(0, (sumSq-n*mean*mean)/(n-1))
+		}
+
+		s := stats[k.account]
+		s.Mean += mean
+		s.Variance += variance
+		stats[k.account] = s
+	}
+	return stats, nil
+}
+
+// GetForecast projects every live account's balance at the end of this month
+// and the following ones, from its current balance, the scheduled
+// transactions and the historical average of its unscheduled spending per
+// category. The band widens with the square root of the months elapsed, as
+// for a sum of independent monthly deviations.
+func GetForecast(today time.Time, months, historyMonths int) (*Forecast, error) {
+	stats, err := loadSpendingStats(today, historyMonths)
+	if err != nil {
+		return nil, err
+	}
+
+	thisMonth := MonthStart(today)
+	end := thisMonth.AddDate(0, months, -1)
+	todayStr := today.Format(DateLayout)
+
+	horizon := int(end.Sub(today).Hours() / 24)
+	upcoming, err := UpcomingOccurrences(todayStr, horizon)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduled := map[int64]map[string]float64{}
+	for _, o := range upcoming {
+		if scheduled[o.AccountID] == nil {
+			scheduled[o.AccountID] = map[string]float64{}
+		}
+		date, err := parseDate(o.Date)
+		if err != nil {
+			return nil, err
+		}
+		scheduled[o.AccountID][MonthStart(date).Format(MonthLayout)] += o.Amount
+	}
+
+	accounts, err := NewRepository[Account](db, "accounts", "id").List(WithOrderBy("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	forecast := &Forecast{
+		AsOf:          todayStr,
+		Months:        months,
+		HistoryMonths: historyMonths,
+		Confidence:    ForecastConfidence,
+		Accounts:      []AccountForecast{},
+		Total:         []ForecastPoint{},
+	}
+	totalVariance := make([]float64, months)
+
+	for _, a := range accounts {
+		af := AccountForecast{AccountID: a.ID, Name: a.Name, Balance: a.Balance, Series: []ForecastPoint{}}
+		st := stats[a.ID]
+
+		balance := a.Balance
+		elapsed := 0.0
+		for i := 0; i < months; i++ {
+			month := thisMonth.AddDate(0, i, 0)
+			monthEnd := month.AddDate(0, 1, -1)
+			label := month.Format(MonthLayout)
+
+			// Only the rest of the current month is still ahead
+			fraction := 1.0
+			if i == 0 {
+				fraction = monthEnd.Sub(today).Hours() / (monthEnd.Sub(month).Hours() + 24)
+			}
+			elapsed += fraction
+
+			estimated := st.Mean * fraction
+			balance -= scheduled[a.ID][label] + estimated
+			variance := st.Variance * elapsed
+			spread := forecastZ * math.Sqrt(variance)
+
+			af.Series = append(af.Series, ForecastPoint{
+				Period:    label,
+				Date:      monthEnd.Format(DateLayout),
+				Scheduled: scheduled[a.ID][label],
+				Estimated: estimated,
+				Balance:   balance,
+				Lower:     balance - spread,
+				Upper:     balance + spread,
+			})
+
+			if len(forecast.Total) <= i {
+				forecast.Total = append(forecast.Total, ForecastPoint{Period: label, Date: monthEnd.Format(DateLayout)})
+			}
+			t := &forecast.Total[i]
+			t.Scheduled += scheduled[a.ID][label]
+			t.Estimated += estimated
+			t.Balance += balance
+			totalVariance[i] += variance
+		}
+		forecast.Accounts = append(forecast.Accounts, af)
+	}
+
+	for i := range forecast.Total {
+		spread := forecastZ * math.Sqrt(totalVariance[i])
+		forecast.Total[i].Lower = forecast.Total[i].Balance - spread
+		forecast.Total[i].Upper = forecast.Total[i].Balance + spread
+	}
+	return forecast, nil
+}
+
+// HandleForecast handles GET /forecast?months=3..12&history_months=N
+func HandleForecast(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	months := DefaultForecastMonths
+	if v := q.Get("months"); v != "" {
+		var err error
+		months, err = strconv.Atoi(v)
+		if err != nil || months < MinForecastMonths || months > MaxForecastMonths {
+			http.Error(w, "months must be between 3 and 12", http.StatusBadRequest)
+			return
+		}
+	}
+
+	history := DefaultHistoryMonths
+	if v := q.Get("history_months"); v != "" {
+		var err error
+		history, err = strconv.Atoi(v)
+		if err != nil || history < 1 {
+			http.Error(w, "Invalid history_months", http.StatusBadRequest)
+			return
+		}
+	}
+
+	forecast, err := GetForecast(Today(), months, history)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, forecast)
+}
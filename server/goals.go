@@ -0,0 +1,335 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Goal types
+const (
+	// GoalTargetBalance is a balance of TargetAmount available by TargetDate
+	GoalTargetBalance = "target_balance"
+	// GoalMonthlyContribution is TargetAmount funded every month
+	GoalMonthlyContribution = "monthly_contribution"
+	// GoalSpending is TargetAmount needed every month for recurring spending
+	GoalSpending = "spending"
+)
+
+// Goal is a category's savings or spending goal
+type Goal struct {
+	ID           int64     `json:"id"`
+	CategoryID   int64     `db:"category_id" json:"category_id"`
+	Type         string    `json:"type"`
+	TargetAmount float64   `db:"target_amount" json:"target_amount"`
+	TargetDate   *string   `db:"target_date" json:"target_date,omitempty"`
+	CreatedAt    Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt    Timestamp `db:"updated_at" json:"updated_at"`
+	IsDeleted    int       `db:"is_deleted" json:"is_deleted"`
+}
+
+type SetGoalRequest struct {
+	CategoryID   int64   `json:"category_id"`
+	Type         string  `json:"type"`
+	TargetAmount float64 `json:"target_amount"`
+	TargetDate   *string `json:"target_date,omitempty"`
+}
+
+// GoalProgress is a goal as of today. Balance is what the category (with
+// its subcategories) has available, Funded what was assigned to it for this
+// month and Spent its spending this month. Progress runs from 0 to 1;
+// RequiredMonthly is what must be funded each month from now on to reach
+// the goal, and Remaining what is still missing: from the target balance,
+// from this month's funding for monthly contributions, or from what this
+// month's spending and the balance left cover for spending goals.
+type GoalProgress struct {
+	Goal
+	Balance         float64 `json:"balance"`
+	Funded          float64 `json:"funded"`
+	Spent           float64 `json:"spent"`
+	Progress        float64 `json:"progress"`
+	Remaining       float64 `json:"remaining"`
+	RequiredMonthly float64 `json:"required_monthly"`
+	MonthsLeft      *int    `json:"months_left,omitempty"`
+	OnTrack         bool    `json:"on_track"`
+}
+
+func (r SetGoalRequest) validate() error {
+	if r.CategoryID == 0 {
+		return BadRequest("category id is required")
+	}
+	if r.TargetAmount <= 0 {
+		return BadRequest("target_amount must be positive")
+	}
+
+	switch r.Type {
+	case GoalTargetBalance:
+		if r.TargetDate == nil {
+			return BadRequest("a %s goal needs a target_date", GoalTargetBalance)
+		}
+		if _, err := parseDate(*r.TargetDate); err != nil {
+			return BadRequest("target_date must be a YYYY-MM-DD date")
+		}
+	case GoalMonthlyContribution, GoalSpending:
+		if r.TargetDate != nil {
+			return BadRequest("only %s goals have a target_date", GoalTargetBalance)
+		}
+	default:
+		return BadRequest("type must be one of %s, %s, %s", GoalTargetBalance, GoalMonthlyContribution, GoalSpending)
+	}
+	return nil
+}
+
+// SetGoal sets the goal of a live spending category, replacing any earlier one
+func SetGoal(r SetGoalRequest) (int64, error) {
+	var isIncome []bool
+	err := db.Select(&isIncome, `SELECT is_income FROM categories WHERE id = ? AND is_deleted = 0`, r.CategoryID)
+	if err != nil {
+		return 0, err
+	}
+	if len(isIncome) == 0 {
+		return 0, NotFound("category %d not found", r.CategoryID)
+	}
+	if isIncome[0] {
+		return 0, BadRequest("income categories can't have goals")
+	}
+
+	var id int64
+	err = db.Get(&id, `
+		INSERT INTO goals (category_id, type, target_amount, target_date)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (category_id) DO UPDATE
+		SET type = excluded.type,
+			target_amount = excluded.target_amount,
+			target_date = excluded.target_date,
+			created_at = CASE WHEN is_deleted = 1 THEN CURRENT_TIMESTAMP ELSE created_at END,
+			is_deleted = 0,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING id
+	`, r.CategoryID, r.Type, r.TargetAmount, r.TargetDate)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("[DB][OK] set_goal(category_id=%d, type=%s, target_amount=%v, target_date=%s) id=%d\n",
+		r.CategoryID, r.Type, r.TargetAmount, StringValue(r.TargetDate), id)
+	return id, nil
+}
+
+// monthsBetween counts the months from the month period of from to the one
+// of to, both included
+func monthsBetween(from, to time.Time) int {
+	a, b := MonthStart(from), MonthStart(to)
+	return (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month()) + 1
+}
+
+// Progress works out where g stands as of today
+func (g Goal) Progress(balance, funded, spent float64, today time.Time) GoalProgress {
+	p := GoalProgress{Goal: g, Balance: balance, Funded: funded, Spent: spent}
+
+	switch g.Type {
+	case GoalTargetBalance:
+		target, _ := parseDate(StringValue(g.TargetDate))
+		p.Progress = balance / g.TargetAmount
+		p.Remaining = math.Max(0, g.TargetAmount-balance)
+
+		months := max(0, monthsBetween(today, target))
+		p.MonthsLeft = &months
+		switch {
+		case p.Remaining == 0:
+		case months == 0:
+			// Past the date, everything missing is due now
+			p.RequiredMonthly = p.Remaining
+		default:
+			p.RequiredMonthly = p.Remaining / float64(months)
+		}
+
+		// On track while the balance keeps up with a steady climb from
+		// nothing when the goal was set to the target on the date
+		start := g.CreatedAt.Truncate(24 * time.Hour)
+		expected := g.TargetAmount
+		if total := target.Sub(start); total > 0 && today.Before(target) {
+			expected *= math.Max(0, today.Sub(start).Hours()/total.Hours())
+		}
+		p.OnTrack = balance >= expected
+
+	case GoalSpending:
+		// Money already spent this month was there when it was needed, so
+		// the target is covered by that and what is still available,
+		// whether funded this month or carried over
+		covered := spent + balance
+		p.Progress = covered / g.TargetAmount
+		p.Remaining = math.Max(0, g.TargetAmount-covered)
+		p.RequiredMonthly = g.TargetAmount
+		p.OnTrack = p.Remaining == 0
+
+	default:
+		p.Progress = funded / g.TargetAmount
+		p.Remaining = math.Max(0, g.TargetAmount-funded)
+		p.RequiredMonthly = g.TargetAmount
+		p.OnTrack = p.Remaining == 0
+	}
+
+	p.Progress = math.Min(1, math.Max(0, p.Progress))
+	return p
+}
+
+// monthFunding is what was assigned to each of categories for month, rolled
+// up the tree like the budget report: a parent is funded the sum of its
+// children. Unlike the budget report's figures, standing targets don't
+// count, only assignments made for the month.
+func monthFunding(categories []Category, month string) (map[int64]float64, error) {
+	var budgets []monthlyAmount
+	err := db.Select(&budgets, `
+		SELECT category_id, month, amount FROM budgets
+		WHERE is_deleted = 0 AND month = ?
+	`, month)
+	if err != nil {
+		return nil, err
+	}
+	assigned := map[int64]float64{}
+	for _, b := range budgets {
+		assigned[b.CategoryID] = b.Amount
+	}
+
+	funding := map[int64]float64{}
+	var walk func(c *Category) float64
+	walk = func(c *Category) float64 {
+		if len(c.Categories) == 0 {
+			return assigned[c.ID]
+		}
+		var total float64
+		for _, child := range c.Categories {
+			total += walk(child)
+		}
+		return total
+	}
+	for i := range categories {
+		funding[categories[i].ID] = walk(&categories[i])
+	}
+	return funding, nil
+}
+
+// ComputeGoals works out the progress of the goals of categories, which
+// must already carry their subcategories (see BuildCategoryTree) and their
+// amounts (see SumCategoryAmounts)
+func ComputeGoals(categories []Category, today time.Time) (map[int64]*GoalProgress, error) {
+	goals, err := NewRepository[Goal](db, "goals", "id").List()
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[int64]*GoalProgress{}
+	if len(goals) == 0 {
+		return result, nil
+	}
+
+	// This month's funding and spending, rolled up the tree like the amounts
+	month := MonthStart(today)
+	funding, err := monthFunding(categories, month.Format(MonthLayout))
+	if err != nil {
+		return nil, err
+	}
+	report, err := GetBudgetReport(ReportRange{
+		From:   month.Format(DateLayout),
+		To:     month.AddDate(0, 1, -1).Format(DateLayout),
+		Bucket: "month",
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows := map[int64]BudgetRow{}
+	for _, row := range report.Rows {
+		rows[row.CategoryID] = row
+	}
+
+	balances := map[int64]float64{}
+	for _, c := range categories {
+		if c.Amount != nil {
+			balances[c.ID] = *c.Amount
+		}
+	}
+
+	for _, g := range goals {
+		p := g.Progress(balances[g.CategoryID], funding[g.CategoryID], rows[g.CategoryID].Actual, today)
+		result[g.CategoryID] = &p
+	}
+	return result, nil
+}
+
+// AttachGoals sets the Goal of each of categories that has one
+func AttachGoals(categories []Category) error {
+	goals, err := ComputeGoals(categories, Today())
+	if err != nil {
+		return err
+	}
+	for i := range categories {
+		categories[i].Goal = goals[categories[i].ID]
+	}
+	return nil
+}
+
+// HandleGetGoals handles GET /goals
+func HandleGetGoals(w http.ResponseWriter, r *http.Request) {
+	categories, err := NewRepository[Category](db, "categories", "id").List(WithOrderBy("sort_order"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	for _, root := range BuildCategoryTree(categories) {
+		SumCategoryAmounts(root)
+	}
+
+	goals, err := ComputeGoals(categories, Today())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	// Goals of deleted categories are left out
+	result := []GoalProgress{}
+	for _, c := range categories {
+		if g, ok := goals[c.ID]; ok {
+			result = append(result, *g)
+		}
+	}
+
+	WriteJSON(w, result)
+}
+
+// HandleSetGoal handles PUT /goals
+func HandleSetGoal(w http.ResponseWriter, r *http.Request) {
+	var req SetGoalRequest
+
+	HandleCreate(
+		w,
+		r,
+		&req,
+		SetGoalRequest.validate,
+		SetGoal,
+	)
+}
+
+// HandleDeleteGoal handles DELETE /goals/{id}
+func HandleDeleteGoal(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	repo := NewRepository[Goal](db, "goals", "id")
+	if _, err := repo.GetByID(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := repo.Delete(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
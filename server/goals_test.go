@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGoalProgress(t *testing.T) {
+	today := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name                   string
+		goalType               string
+		balance, funded, spent float64
+		wantProgress           float64
+		wantRemaining          float64
+		wantOnTrack            bool
+	}{
+		{"contribution funded", GoalMonthlyContribution, 0, 100, 0, 1, 0, true},
+		{"contribution half funded", GoalMonthlyContribution, 300, 50, 0, 0.5, 50, false},
+		{"spending partly spent", GoalSpending, 70, 100, 30, 1, 0, true},
+		{"spending carried over", GoalSpending, 100, 0, 0, 1, 0, true},
+		{"spending short", GoalSpending, 20, 50, 30, 0.5, 50, false},
+		{"spending overspent", GoalSpending, -20, 100, 120, 1, 0, true},
+		{"spending overspent unfunded", GoalSpending, -40, 40, 80, 0.4, 60, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := Goal{Type: tt.goalType, TargetAmount: 100}
+			p := g.Progress(tt.balance, tt.funded, tt.spent, today)
+
+			if p.Progress != tt.wantProgress {
+				t.Errorf("progress = %v, want %v", p.Progress, tt.wantProgress)
+			}
+			if p.Remaining != tt.wantRemaining {
+				t.Errorf("remaining = %v, want %v", p.Remaining, tt.wantRemaining)
+			}
+			if p.OnTrack != tt.wantOnTrack {
+				t.Errorf("on track = %v, want %v", p.OnTrack, tt.wantOnTrack)
+			}
+		})
+	}
+}
+
+func TestComputeGoalsFunding(t *testing.T) {
+	l := newLedger(t)
+	today := Today()
+	month := MonthStart(today).Format(MonthLayout)
+
+	// Transport has no assignment this month, only its standing amount
+	mustExec(t, `INSERT INTO budgets (category_id, month, amount) VALUES (?, ?, 80)`, l.food, month)
+	for _, id := range []int64{l.food, l.transport} {
+		if _, err := SetGoal(SetGoalRequest{CategoryID: id, Type: GoalMonthlyContribution, TargetAmount: 100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	categories, err := NewRepository[Category](db, "categories", "id").List(WithOrderBy("sort_order"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range BuildCategoryTree(categories) {
+		SumCategoryAmounts(root)
+	}
+
+	goals, err := ComputeGoals(categories, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := goals[l.food].Funded; got != 80 {
+		t.Errorf("food funded = %v, want 80", got)
+	}
+	if got := goals[l.transport].Funded; got != 0 {
+		t.Errorf("transport funded = %v, want 0", got)
+	}
+}
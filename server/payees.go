@@ -0,0 +1,431 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultPayeeSearchLimit is how many payees autocomplete returns when the
+// request doesn't say
+const DefaultPayeeSearchLimit = 10
+
+// Payee is a normalized transaction counterparty
+type Payee struct {
+	ID                int64      `json:"id"`
+	Name              string     `json:"name"`
+	Normalized        string     `json:"normalized"`
+	DefaultCategoryID *int64     `db:"default_category_id" json:"default_category_id,omitempty"`
+	UseCount          int64      `db:"use_count" json:"use_count"`
+	LastUsedAt        *Timestamp `db:"last_used_at" json:"last_used_at,omitempty"`
+	CreatedAt         Timestamp  `db:"created_at" json:"created_at"`
+	UpdatedAt         Timestamp  `db:"updated_at" json:"updated_at"`
+	IsDeleted         int        `db:"is_deleted" json:"is_deleted"`
+}
+
+type UpdatePayeeRequest struct {
+	Name              *string `json:"name,omitempty"`
+	DefaultCategoryID *int64  `json:"default_category_id,omitempty"`
+}
+
+type PayeeAliasRequest struct {
+	Alias string `json:"alias"`
+}
+
+// payeeSuffixes are trailing words that don't tell payees apart
+var payeeSuffixes = map[string]bool{
+	"com": true, "co": true, "corp": true, "inc": true, "llc": true, "ltd": true,
+}
+
+// NormalizePayee reduces a payee name to its matching key: lower case,
+// punctuation dropped, whitespace collapsed and trailing company suffixes
+// removed, so "AMAZON", "Amazon.com" and " amazon " all become "amazon"
+func NormalizePayee(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	n := len(words)
+	for n > 1 && payeeSuffixes[words[n-1]] {
+		n--
+	}
+	return strings.Join(words[:n], " ")
+}
+
+// findPayee resolves a normalized name through the aliases and payees,
+// returning nil when nothing matches
+func findPayee(q sqlx.Queryer, normalized string) (*Payee, error) {
+	var p Payee
+	err := sqlx.Get(q, &p, `
+		SELECT p.* FROM payees p
+		WHERE p.is_deleted = 0 AND (
+			p.normalized = ?
+			OR p.id IN (SELECT payee_id FROM payee_aliases WHERE normalized = ?)
+		)
+		LIMIT 1
+	`, normalized, normalized)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ResolvePayee finds the payee for name, creating it when it's new
+func ResolvePayee(tx *sqlx.Tx, name string) (*Payee, error) {
+	normalized := NormalizePayee(name)
+	if normalized == "" {
+		return nil, BadRequest("payee %q has no letters or digits", name)
+	}
+
+	p, err := findPayee(tx, normalized)
+	if err != nil || p != nil {
+		return p, err
+	}
+
+	var created Payee
+	err = tx.Get(&created, `
+		INSERT INTO payees (name, normalized) VALUES (?, ?) RETURNING *
+	`, strings.TrimSpace(name), normalized)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[DB][OK] insert_payee(name=%s, normalized=%s) id=%d\n", created.Name, normalized, created.ID)
+	return &created, nil
+}
+
+// UsePayee resolves name and records a use of the payee. A payee without a
+// default category adopts categoryID.
+func UsePayee(tx *sqlx.Tx, name string, categoryID *int64) (*Payee, error) {
+	p, err := ResolvePayee(tx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var used Payee
+	err = tx.Get(&used, `
+		UPDATE payees
+		SET use_count = use_count + 1,
+			last_used_at = CURRENT_TIMESTAMP,
+			default_category_id = COALESCE(default_category_id, ?)
+		WHERE id = ?
+		RETURNING *
+	`, categoryID, p.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &used, nil
+}
+
+// PayeeDefaultCategory is the default category of the payee name resolves
+// to, nil when there is none
+func PayeeDefaultCategory(q sqlx.Queryer, name string) (*int64, error) {
+	p, err := findPayee(q, NormalizePayee(name))
+	if err != nil || p == nil {
+		return nil, err
+	}
+	return p.DefaultCategoryID, nil
+}
+
+// SearchPayees autocompletes q against payee names and aliases. Payees whose
+// name starts with q come before those merely containing it, then the most
+// recently and most often used. An empty q lists the most recent payees.
+func SearchPayees(q string, limit int) ([]Payee, error) {
+	normalized := NormalizePayee(q)
+
+	payees := []Payee{}
+	err := db.Select(&payees, `
+		SELECT p.* FROM payees p
+		WHERE p.is_deleted = 0 AND (
+			p.normalized LIKE '%' || ? || '%'
+			OR p.id IN (SELECT payee_id FROM payee_aliases WHERE normalized LIKE '%' || ? || '%')
+		)
+		ORDER BY
+			p.normalized LIKE ? || '%' DESC,
+			p.last_used_at IS NULL,
+			p.last_used_at DESC,
+			p.use_count DESC,
+			p.name
+		LIMIT ?
+	`, normalized, normalized, normalized, limit)
+	if err != nil {
+		return nil, err
+	}
+	return payees, nil
+}
+
+// UpdatePayee renames a payee and/or changes its default category. A rename
+// keeps the old name as an alias and rewrites the payee on every transaction
+// linked to it, deleted ones included.
+func UpdatePayee(id int64, req UpdatePayeeRequest) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var p Payee
+	if err := tx.Get(&p, `SELECT * FROM payees WHERE id = ? AND is_deleted = 0`, id); err != nil {
+		return err
+	}
+
+	if req.DefaultCategoryID != nil {
+		var alive int
+		err := tx.Get(&alive, `SELECT COUNT(*) FROM categories WHERE id = ? AND is_deleted = 0`, *req.DefaultCategoryID)
+		if err != nil {
+			return err
+		}
+		if alive == 0 {
+			return NotFound("category %d not found", *req.DefaultCategoryID)
+		}
+
+		_, err = tx.Exec(`
+			UPDATE payees SET default_category_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+		`, *req.DefaultCategoryID, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if req.Name != nil && strings.TrimSpace(*req.Name) != p.Name {
+		name := strings.TrimSpace(*req.Name)
+		normalized := NormalizePayee(name)
+		if normalized == "" {
+			return BadRequest("payee %q has no letters or digits", name)
+		}
+
+		other, err := findPayee(tx, normalized)
+		if err != nil {
+			return err
+		}
+		if other != nil && other.ID != id {
+			return Conflict("%q is already payee %d", name, other.ID)
+		}
+
+		if normalized != p.Normalized {
+			// The new name may have been one of this payee's aliases
+			_, err = tx.Exec(`DELETE FROM payee_aliases WHERE normalized = ?`, normalized)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`
+				INSERT INTO payee_aliases (payee_id, alias, normalized) VALUES (?, ?, ?)
+			`, id, p.Name, p.Normalized)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(`
+			UPDATE payees SET name = ?, normalized = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+		`, name, normalized, id)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.Exec(`
+			UPDATE transactions SET payee = ?, updated_at = CURRENT_TIMESTAMP WHERE payee_id = ?
+		`, name, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		log.Printf("[DB][OK] rename_payee(id=%d, from=%s, to=%s) transactions=%d\n", id, p.Name, name, n)
+	}
+
+	return tx.Commit()
+}
+
+// AddPayeeAlias makes alias resolve to the payee
+func AddPayeeAlias(id int64, alias string) (int64, error) {
+	normalized := NormalizePayee(alias)
+	if normalized == "" {
+		return 0, BadRequest("alias %q has no letters or digits", alias)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var alive int
+	if err := tx.Get(&alive, `SELECT COUNT(*) FROM payees WHERE id = ? AND is_deleted = 0`, id); err != nil {
+		return 0, err
+	}
+	if alive == 0 {
+		return 0, NotFound("payee %d not found", id)
+	}
+
+	other, err := findPayee(tx, normalized)
+	if err != nil {
+		return 0, err
+	}
+	if other != nil {
+		return 0, Conflict("%q already resolves to payee %d", alias, other.ID)
+	}
+
+	var aliasID int64
+	err = tx.Get(&aliasID, `
+		INSERT INTO payee_aliases (payee_id, alias, normalized) VALUES (?, ?, ?) RETURNING id
+	`, id, strings.TrimSpace(alias), normalized)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("[DB][OK] insert_payee_alias(payee_id=%d, alias=%s) id=%d\n", id, alias, aliasID)
+	return aliasID, nil
+}
+
+// RebuildPayees links transactions entered before payees existed, creating
+// payees as needed, then recounts every payee's uses from its transactions
+func RebuildPayees() (int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var unlinked []Transaction
+	err = tx.Select(&unlinked, `
+		SELECT * FROM transactions
+		WHERE payee_id IS NULL AND TRIM(COALESCE(payee, '')) != ''
+		ORDER BY date(date), id
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, t := range unlinked {
+		p, err := UsePayee(tx, *t.Payee, t.CategoryID)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(`UPDATE transactions SET payee_id = ?, payee = ? WHERE id = ?`, p.ID, p.Name, t.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE payees
+		SET use_count = u.uses, last_used_at = u.last_used
+		FROM (
+			SELECT payee_id, COUNT(*) AS uses, datetime(MAX(date(date))) AS last_used
+			FROM transactions
+			WHERE is_deleted = 0 AND payee_id IS NOT NULL
+			GROUP BY payee_id
+		) AS u
+		WHERE payees.id = u.payee_id
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("[DB][OK] rebuild_payees() linked=%d\n", len(unlinked))
+	return int64(len(unlinked)), nil
+}
+
+// HandleSearchPayees handles GET /payees?q=...&limit=N
+func HandleSearchPayees(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultPayeeSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	payees, err := SearchPayees(r.URL.Query().Get("q"), limit)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, payees)
+}
+
+// HandleUpdatePayee handles PUT /payees/{id}
+func HandleUpdatePayee(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	var req UpdatePayeeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := UpdatePayee(id, req); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
+
+// HandleAddPayeeAlias handles POST /payees/{id}/aliases
+func HandleAddPayeeAlias(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	var req PayeeAliasRequest
+	HandleCreate(
+		w,
+		r,
+		&req,
+		func(r PayeeAliasRequest) error {
+			if strings.TrimSpace(r.Alias) == "" {
+				return BadRequest("alias is required")
+			}
+			return nil
+		},
+		func(r PayeeAliasRequest) (int64, error) {
+			return AddPayeeAlias(id, r.Alias)
+		},
+	)
+}
+
+// HandleRebuildPayees handles POST /payees/rebuild
+func HandleRebuildPayees(w http.ResponseWriter, r *http.Request) {
+	n, err := RebuildPayees()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"status": "OK",
+		"linked": n,
+	})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportPeriod is a custom named date range, like a tax year or a trip.
+// Anywhere a relative range like "last_month" is accepted, so is its name.
+type ReportPeriod struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	StartDate string    `db:"start_date" json:"start_date"`
+	EndDate   string    `db:"end_date" json:"end_date"`
+	CreatedAt Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt Timestamp `db:"updated_at" json:"updated_at"`
+	IsDeleted int       `db:"is_deleted" json:"is_deleted"`
+}
+
+type ReportPeriodRequest struct {
+	Name      string `json:"name"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+// validate checks the request against the live periods other than id
+func (r ReportPeriodRequest) validate(id int64) error {
+	name := strings.TrimSpace(r.Name)
+	if name == "" {
+		return BadRequest("name is required")
+	}
+	if _, _, err := RelativeRange(name, Today()); err == nil {
+		return BadRequest("%q is the name of a relative range", name)
+	}
+
+	start, err := time.Parse(DateLayout, r.StartDate)
+	if err != nil {
+		return BadRequest("start_date must be a YYYY-MM-DD date")
+	}
+	end, err := time.Parse(DateLayout, r.EndDate)
+	if err != nil {
+		return BadRequest("end_date must be a YYYY-MM-DD date")
+	}
+	if end.Before(start) {
+		return BadRequest("end_date must not be before start_date")
+	}
+
+	var existing []int64
+	err = db.Select(&existing, `
+		SELECT id FROM periods WHERE name = ? COLLATE NOCASE AND is_deleted = 0 AND id != ?
+	`, name, id)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return Conflict("period %q already exists", name)
+	}
+	return nil
+}
+
+// ResolvePeriod resolves a period selector to its first and last day: a
+// relative range as of today, or else the name of a custom period
+func ResolvePeriod(name string, today time.Time) (time.Time, time.Time, error) {
+	if start, end, err := RelativeRange(name, today); err == nil {
+		return start, end, nil
+	}
+
+	var p ReportPeriod
+	err := db.Get(&p, `SELECT * FROM periods WHERE name = ? COLLATE NOCASE AND is_deleted = 0`, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, time.Time{}, BadRequest("unknown period %q, expected a relative range like this_month or a saved period", name)
+	}
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	start, err := time.Parse(DateLayout, p.StartDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end, err := time.Parse(DateLayout, p.EndDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return start, end, nil
+}
+
+// DeletePeriod moves a period to the trash. A period saved filters still
+// select by name can't be deleted; the error names those filters.
+func DeletePeriod(id int64) error {
+	repo := NewRepository[ReportPeriod](db, "periods", "id")
+	p, err := repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+
+	filters, err := NewRepository[SavedFilter](db, "saved_filters", "id").List(WithOrderBy("name"))
+	if err != nil {
+		return err
+	}
+	var users []string
+	for _, f := range filters {
+		values, err := url.ParseQuery(f.Query)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(values.Get("range")), p.Name) {
+			users = append(users, strconv.Quote(f.Name))
+		}
+	}
+	if len(users) > 0 {
+		return Conflict("period %q is the range of saved filters %s, change them first", p.Name, strings.Join(users, ", "))
+	}
+
+	if err := repo.Delete(id); err != nil {
+		return err
+	}
+
+	log.Printf("[DB][OK] delete_period(id=%d, name=%s)\n", id, p.Name)
+	return nil
+}
+
+// HandleGetPeriods handles GET /periods
+func HandleGetPeriods(w http.ResponseWriter, r *http.Request) {
+	periods, err := NewRepository[ReportPeriod](db, "periods", "id").List(WithOrderBy("start_date, name"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if periods == nil {
+		periods = []ReportPeriod{}
+	}
+
+	WriteJSON(w, periods)
+}
+
+// HandleCreatePeriod handles POST /periods
+func HandleCreatePeriod(w http.ResponseWriter, r *http.Request) {
+	var req ReportPeriodRequest
+
+	HandleCreate(
+		w,
+		r,
+		&req,
+		nil,
+		func(r ReportPeriodRequest) (int64, error) {
+			if err := r.validate(0); err != nil {
+				return 0, err
+			}
+
+			id, err := NewRepository[ReportPeriod](db, "periods", "id").Create(map[string]interface{}{
+				"name":       strings.TrimSpace(r.Name),
+				"start_date": r.StartDate,
+				"end_date":   r.EndDate,
+			})
+			if err != nil {
+				return 0, err
+			}
+
+			log.Printf("[DB][OK] create_period(id=%d, name=%s, start_date=%s, end_date=%s)\n", id, r.Name, r.StartDate, r.EndDate)
+			return id, nil
+		},
+	)
+}
+
+// HandleUpdatePeriod handles PUT /periods/{id}
+func HandleUpdatePeriod(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	var req ReportPeriodRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	repo := NewRepository[ReportPeriod](db, "periods", "id")
+	if _, err := repo.GetByID(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := req.validate(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+	err = repo.Update(id, map[string]interface{}{
+		"name":       strings.TrimSpace(req.Name),
+		"start_date": req.StartDate,
+		"end_date":   req.EndDate,
+	})
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
+
+// HandleDeletePeriod handles DELETE /periods/{id}
+func HandleDeletePeriod(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	if err := DeletePeriod(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// DateLayout is the ISO calendar date format used by report parameters
+const DateLayout = "2006-01-02"
+
+// Bucket expressions group a transaction date (any format SQLite's date()
+// understands) into a report period. Weeks and months start as configured
+// in the settings, see bucketExpr.
+var bucketExprs = map[string]string{
+	"day":   "date(%s)",
+	"week":  "date(%s, 'weekday %d', '-6 days')",
+	"month": "strftime('%%Y-%%m', date(%s, '-%d days'))",
+	"year":  "strftime('%%Y', %s)",
+	// Named like FiscalYearLabel after the year the fiscal year starts in
+	"fiscal_year": `(SELECT CASE WHEN %[2]d = 1 THEN printf('FY%%d', y) ELSE printf('FY%%d-%%02d', y, (y + 1) %% 100) END
+		FROM (SELECT CAST(strftime('%%Y', date(%[1]s, '-%[3]d months')) AS INTEGER) AS y))`,
+}
+
+// BucketExpr returns the SQL expression grouping column into bucket periods
+func BucketExpr(bucket, column string) (string, error) {
+	expr, ok := bucketExprs[bucket]
+	if !ok {
+		return "", BadRequest("bucket must be one of day, week, month, year, fiscal_year")
+	}
+
+	s := CurrentSettings()
+	switch bucket {
+	case "week":
+		// Step forward to the last day of the week, then back to its first
+		return fmt.Sprintf(expr, column, (s.WeekStart+6)%7), nil
+	case "month":
+		// A month named after the month it starts in
+		return fmt.Sprintf(expr, column, s.MonthStartDay-1), nil
+	case "fiscal_year":
+		return fmt.Sprintf(expr, column, s.FiscalYearStartMonth, s.FiscalYearStartMonth-1), nil
+	}
+	return fmt.Sprintf(expr, column), nil
+}
+
+// RelativeRange resolves a named range like "last_month" to its first and
+// last day as of today. Weeks, months and quarters follow the settings;
+// quarters are quarters of the fiscal year.
+func RelativeRange(name string, today time.Time) (time.Time, time.Time, error) {
+	day := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	week := WeekStart(day)
+	month := MonthStart(day)
+	quarter := QuarterStart(day)
+	year := time.Date(day.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	fiscalYear := FiscalYearStart(day)
+
+	switch name {
+	case "today":
+		return day, day, nil
+	case "yesterday":
+		return day.AddDate(0, 0, -1), day.AddDate(0, 0, -1), nil
+	case "this_week":
+		return week, week.AddDate(0, 0, 6), nil
+	case "last_week":
+		return week.AddDate(0, 0, -7), week.AddDate(0, 0, -1), nil
+	case "this_month":
+		return month, month.AddDate(0, 1, -1), nil
+	case "last_month":
+		return month.AddDate(0, -1, 0), month.AddDate(0, 0, -1), nil
+	case "month_to_date":
+		return month, day, nil
+	case "this_quarter":
+		return quarter, quarter.AddDate(0, 3, -1), nil
+	case "last_quarter":
+		return quarter.AddDate(0, -3, 0), quarter.AddDate(0, 0, -1), nil
+	case "quarter_to_date":
+		return quarter, day, nil
+	case "this_year":
+		return year, year.AddDate(1, 0, -1), nil
+	case "last_year":
+		return year.AddDate(-1, 0, 0), year.AddDate(0, 0, -1), nil
+	case "year_to_date":
+		return year, day, nil
+	case "this_fiscal_year":
+		return fiscalYear, fiscalYear.AddDate(1, 0, -1), nil
+	case "last_fiscal_year":
+		return fiscalYear.AddDate(-1, 0, 0), fiscalYear.AddDate(0, 0, -1), nil
+	case "fiscal_year_to_date":
+		return fiscalYear, day, nil
+	case "last_7_days":
+		return day.AddDate(0, 0, -6), day, nil
+	case "last_30_days":
+		return day.AddDate(0, 0, -29), day, nil
+	case "last_90_days":
+		return day.AddDate(0, 0, -89), day, nil
+	case "last_12_months":
+		return month.AddDate(-1, 0, 0), month.AddDate(0, 0, -1), nil
+	}
+	return time.Time{}, time.Time{}, BadRequest("unknown range %q", name)
+}
+
+// ReportRange is the date range and bucket shared by the report endpoints.
+// Period is the selector the range came from, if any, and Compare asks for
+// the figures of the same range a year earlier alongside.
+type ReportRange struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Bucket  string `json:"bucket"`
+	Period  string `json:"period,omitempty"`
+	Compare string `json:"compare,omitempty"`
+}
+
+// CompareYearOverYear is the compare value for year-over-year figures
+const CompareYearOverYear = "previous_year"
+
+// ParseReportRange reads ?from=YYYY-MM-DD&to=YYYY-MM-DD&bucket=... or
+// ?period=...&bucket=..., where period is anything ResolvePeriod accepts,
+// plus an optional compare=previous_year. The range defaults to the current
+// year to date, the bucket to month.
+func ParseReportRange(r *http.Request) (ReportRange, error) {
+	today := Today()
+	rr := ReportRange{
+		From:   time.Date(today.Year(), 1, 1, 0, 0, 0, 0, time.UTC).Format(DateLayout),
+		To:     today.Format(DateLayout),
+		Bucket: "month",
+	}
+
+	q := r.URL.Query()
+	if v := q.Get("from"); v != "" {
+		rr.From = v
+	}
+	if v := q.Get("to"); v != "" {
+		rr.To = v
+	}
+	if v := q.Get("bucket"); v != "" {
+		rr.Bucket = v
+	}
+	if v := q.Get("period"); v != "" {
+		if q.Has("from") || q.Has("to") {
+			return rr, BadRequest("period can't be combined with from and to")
+		}
+		start, end, err := ResolvePeriod(v, today)
+		if err != nil {
+			return rr, err
+		}
+		rr.From, rr.To, rr.Period = start.Format(DateLayout), end.Format(DateLayout), v
+	}
+	if v := q.Get("compare"); v != "" {
+		if v != CompareYearOverYear {
+			return rr, BadRequest("compare must be %s", CompareYearOverYear)
+		}
+		rr.Compare = v
+	}
+
+	from, err := time.Parse(DateLayout, rr.From)
+	if err != nil {
+		return rr, BadRequest("from must be a YYYY-MM-DD date")
+	}
+	to, err := time.Parse(DateLayout, rr.To)
+	if err != nil {
+		return rr, BadRequest("to must be a YYYY-MM-DD date")
+	}
+	if to.Before(from) {
+		return rr, BadRequest("to must not be before from")
+	}
+	if _, err := BucketExpr(rr.Bucket, "date"); err != nil {
+		return rr, err
+	}
+	return rr, nil
+}
+
+// PreviousYear is the same range and bucket a year earlier
+func (rr ReportRange) PreviousYear() ReportRange {
+	from, _ := time.Parse(DateLayout, rr.From)
+	to, _ := time.Parse(DateLayout, rr.To)
+	return ReportRange{
+		From:   from.AddDate(-1, 0, 0).Format(DateLayout),
+		To:     to.AddDate(-1, 0, 0).Format(DateLayout),
+		Bucket: rr.Bucket,
+	}
+}
+
+// previousPeriods maps the label of each period of rr to the label of the
+// period in the same position a year earlier
+func previousPeriods(rr ReportRange) map[string]string {
+	current, previous := Periods(rr), Periods(rr.PreviousYear())
+	labels := map[string]string{}
+	for i := range min(len(current), len(previous)) {
+		labels[current[i].Label] = previous[i].Label
+	}
+	return labels
+}
+
+// PercentChange is the change from previous to current in percent, nil
+// when there is nothing to compare against
+func PercentChange(current, previous float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := (current - previous) / math.Abs(previous) * 100
+	return &change
+}
+
+// SpendingRow is the total of one group in one period. With compare,
+// Previous is the group's row a year earlier and Change the percent change
+// of the total.
+type SpendingRow struct {
+	Period   string       `db:"period" json:"period"`
+	ID       *int64       `db:"key_id" json:"id,omitempty"`
+	Name     string       `db:"key" json:"name"`
+	ParentID *int64       `db:"parent_id" json:"parent_id,omitempty"`
+	Total    float64      `db:"total" json:"total"`
+	Count    int          `db:"count" json:"count"`
+	Previous *SpendingRow `db:"-" json:"previous,omitempty"`
+	Change   *float64     `db:"-" json:"change,omitempty"`
+}
+
+func (row SpendingRow) groupKey(period string) string {
+	if row.ID != nil {
+		return fmt.Sprintf("%s/%d", period, *row.ID)
+	}
+	return period + "/" + row.Name
+}
+
+// SpendingReport is the response of GET /reports/spending
+type SpendingReport struct {
+	ReportRange
+	GroupBy string        `json:"group_by"`
+	Rows    []SpendingRow `json:"rows"`
+}
+
+// categoryLineageCTE pairs every category with itself and each of its
+// ancestors, so joining transactions through it rolls amounts up the tree
+// the way SumCategoryAmounts does
+var categoryLineageCTE = fmt.Sprintf(`
+	lineage(category_id, ancestor_id, depth) AS (
+		SELECT id, id, 0 FROM categories
+		UNION ALL
+		SELECT l.category_id, c.parent_id, l.depth + 1
+		FROM lineage l JOIN categories c ON c.id = l.ancestor_id
+		WHERE c.parent_id IS NOT NULL AND l.depth < %d
+	)`, MaxCategoryDepth)
+
+// spendingGroups maps group_by to the joins and key columns of the query
+var spendingGroups = map[string]struct {
+	with  string
+	join  string
+	key   string
+	order string
+}{
+	"category": {
+		with: "WITH RECURSIVE " + categoryLineageCTE,
+		join: `LEFT JOIN lineage l ON l.category_id = t.category_id
+			LEFT JOIN categories g ON g.id = l.ancestor_id`,
+		key:   "g.id AS key_id, COALESCE(g.name, 'Uncategorized') AS key, g.parent_id AS parent_id",
+		order: "g.sort_order",
+	},
+	"account": {
+		join:  "JOIN accounts g ON g.id = t.account_id",
+		key:   "g.id AS key_id, g.name AS key, NULL AS parent_id",
+		order: "g.name",
+	},
+	"payee": {
+		join:  "LEFT JOIN payees g ON g.id = t.payee_id",
+		key:   "g.id AS key_id, COALESCE(g.name, NULLIF(TRIM(t.payee), ''), '(no payee)') AS key, NULL AS parent_id",
+		order: "key",
+	},
+	// A transaction with several tags counts towards each of them
+	"tag": {
+		join: `LEFT JOIN transaction_tags tt ON tt.transaction_id = t.id
+				AND tt.tag_id IN (SELECT id FROM tags WHERE is_deleted = 0)
+			LEFT JOIN tags g ON g.id = tt.tag_id`,
+		key:   "g.id AS key_id, COALESCE(g.name, '(untagged)') AS key, NULL AS parent_id",
+		order: "key",
+	},
+}
+
+// GetSpendingReport totals transactions between rr.From and rr.To per
+// group_by key and bucket. Category totals include their subcategories.
+// Income categories and transfers between accounts aren't spending and are
+// left out.
+func GetSpendingReport(rr ReportRange, groupBy string) (*SpendingReport, error) {
+	group, ok := spendingGroups[groupBy]
+	if !ok {
+		return nil, BadRequest("group_by must be one of category, account, payee, tag")
+	}
+
+	period, err := BucketExpr(rr.Bucket, "t.date")
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		%s
+		SELECT
+			%s AS period,
+			%s,
+			SUM(t.amount) AS total,
+			COUNT(*) AS count
+		FROM transactions t
+		%s
+		WHERE t.is_deleted = 0
+		  AND t.transfer_account_id IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM categories ic WHERE ic.id = t.category_id AND ic.is_income = 1)
+		  AND date(t.date) BETWEEN ? AND ?
+		GROUP BY period, key_id, key
+		ORDER BY period, %s
+	`, group.with, period, group.key, group.join, group.order)
+
+	report := &SpendingReport{ReportRange: rr, GroupBy: groupBy, Rows: []SpendingRow{}}
+	if err := db.Select(&report.Rows, query, rr.From, rr.To); err != nil {
+		return nil, err
+	}
+
+	if rr.Compare == CompareYearOverYear {
+		previous, err := GetSpendingReport(rr.PreviousYear(), groupBy)
+		if err != nil {
+			return nil, err
+		}
+		byKey := map[string]SpendingRow{}
+		for _, row := range previous.Rows {
+			byKey[row.groupKey(row.Period)] = row
+		}
+
+		labels := previousPeriods(rr)
+		for i, row := range report.Rows {
+			// Groups without spending a year earlier compare against zero
+			prev, ok := byKey[row.groupKey(labels[row.Period])]
+			if !ok {
+				prev = SpendingRow{Period: labels[row.Period], ID: row.ID, Name: row.Name, ParentID: row.ParentID}
+			}
+			report.Rows[i].Previous = &prev
+			report.Rows[i].Change = PercentChange(row.Total, prev.Total)
+		}
+	}
+	return report, nil
+}
+
+// HandleSpendingReport handles
+// GET /reports/spending?group_by=...&from=...&to=...|period=...&bucket=...&compare=...
+func HandleSpendingReport(w http.ResponseWriter, r *http.Request) {
+	rr, err := ParseReportRange(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = "category"
+	}
+
+	report, err := GetSpendingReport(rr, groupBy)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, report)
+}
+
+// CashFlowRow is the income and spending of one period. SavingsRate is nil
+// when there was no income. With compare, Previous is the period a year
+// earlier and Change the percent change of the net savings.
+type CashFlowRow struct {
+	Period      string       `db:"period" json:"period"`
+	Income      float64      `db:"income" json:"income"`
+	Expenses    float64      `db:"expenses" json:"expenses"`
+	Net         float64      `db:"net" json:"net"`
+	SavingsRate *float64     `db:"savings_rate" json:"savings_rate"`
+	Previous    *CashFlowRow `db:"-" json:"previous,omitempty"`
+	Change      *float64     `db:"-" json:"change,omitempty"`
+}
+
+// CashFlowReport is the response of GET /reports/cash-flow
+type CashFlowReport struct {
+	ReportRange
+	Rows []CashFlowRow `json:"rows"`
+}
+
+// incomeCondition is true for transactions counted as income: anything in an
+// income category, and uncategorized inflows. Everything else is an expense,
+// so refunds in spending categories reduce expenses rather than add income.
+const incomeCondition = `(c.is_income = 1 OR (c.id IS NULL AND t.amount < 0))`
+
+// GetCashFlowReport totals income, expenses, net savings and savings rate per
+// bucket, leaving out transfers between own accounts
+func GetCashFlowReport(rr ReportRange) (*CashFlowReport, error) {
+	period, err := BucketExpr(rr.Bucket, "t.date")
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			period,
+			income,
+			expenses,
+			income - expenses AS net,
+			CASE WHEN income > 0 THEN (income - expenses) / income END AS savings_rate
+		FROM (
+			SELECT
+				%s AS period,
+				COALESCE(SUM(CASE WHEN %s THEN -t.amount ELSE 0 END), 0) AS income,
+				COALESCE(SUM(CASE WHEN %s THEN 0 ELSE t.amount END), 0) AS expenses
+			FROM transactions t
+			LEFT JOIN categories c ON c.id = t.category_id
+			WHERE t.is_deleted = 0
+			  AND t.transfer_account_id IS NULL
+			  AND date(t.date) BETWEEN ? AND ?
+			GROUP BY period
+		)
+		ORDER BY period
+	`, period, incomeCondition, incomeCondition)
+
+	report := &CashFlowReport{ReportRange: rr, Rows: []CashFlowRow{}}
+	if err := db.Select(&report.Rows, query, rr.From, rr.To); err != nil {
+		return nil, err
+	}
+
+	if rr.Compare == CompareYearOverYear {
+		previous, err := GetCashFlowReport(rr.PreviousYear())
+		if err != nil {
+			return nil, err
+		}
+		byPeriod := map[string]CashFlowRow{}
+		for _, row := range previous.Rows {
+			byPeriod[row.Period] = row
+		}
+
+		labels := previousPeriods(rr)
+		for i, row := range report.Rows {
+			prev, ok := byPeriod[labels[row.Period]]
+			if !ok {
+				prev = CashFlowRow{Period: labels[row.Period]}
+			}
+			report.Rows[i].Previous = &prev
+			report.Rows[i].Change = PercentChange(row.Net, prev.Net)
+		}
+	}
+	return report, nil
+}
+
+// HandleCashFlowReport handles
+// GET /reports/cash-flow?from=...&to=...|period=...&bucket=...&compare=...
+func HandleCashFlowReport(w http.ResponseWriter, r *http.Request) {
+	rr, err := ParseReportRange(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	report, err := GetCashFlowReport(rr)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, report)
+}
+
+// Period is one bucket of a report range, Start and End inclusive
+type Period struct {
+	Label string    `json:"period"`
+	Start time.Time `json:"-"`
+	End   time.Time `json:"-"`
+}
+
+// Periods splits rr into its buckets, labelled like BucketExpr labels them.
+// The first and last period are clipped to the range.
+func Periods(rr ReportRange) []Period {
+	from, _ := time.Parse(DateLayout, rr.From)
+	to, _ := time.Parse(DateLayout, rr.To)
+
+	var periods []Period
+	for start := from; !start.After(to); {
+		var label string
+		var next time.Time
+		switch rr.Bucket {
+		case "day":
+			label = start.Format(DateLayout)
+			next = start.AddDate(0, 0, 1)
+		case "week":
+			week := WeekStart(start)
+			label = week.Format(DateLayout)
+			next = week.AddDate(0, 0, 7)
+		case "month":
+			month := MonthStart(start)
+			label = month.Format("2006-01")
+			next = month.AddDate(0, 1, 0)
+		case "fiscal_year":
+			year := FiscalYearStart(start)
+			label = FiscalYearLabel(year)
+			next = year.AddDate(1, 0, 0)
+		default:
+			label = start.Format("2006")
+			next = time.Date(start.Year()+1, 1, 1, 0, 0, 0, 0, time.UTC)
+		}
+
+		end := next.AddDate(0, 0, -1)
+		if end.After(to) {
+			end = to
+		}
+		periods = append(periods, Period{Label: label, Start: start, End: end})
+		start = next
+	}
+	return periods
+}
+
+// AccountBalance is an account's balance at the end of a period
+type AccountBalance struct {
+	ID      int64   `json:"id"`
+	Name    string  `json:"name"`
+	Type    string  `json:"type"`
+	Balance float64 `json:"balance"`
+}
+
+// NetWorthPoint is the net worth at the end of one period. Liabilities are
+// reported as the positive amount owed. With compare, Previous is the net
+// worth a year earlier and Change its percent change.
+type NetWorthPoint struct {
+	Period      string           `json:"period"`
+	Date        string           `json:"date"`
+	Assets      float64          `json:"assets"`
+	Liabilities float64          `json:"liabilities"`
+	NetWorth    float64          `json:"net_worth"`
+	Accounts    []AccountBalance `json:"accounts"`
+	Previous    *NetWorthPoint   `json:"previous,omitempty"`
+	Change      *float64         `json:"change,omitempty"`
+}
+
+// NetWorthReport is the response of GET /reports/net-worth
+type NetWorthReport struct {
+	ReportRange
+	Series []NetWorthPoint `json:"series"`
+}
+
+// accountHistory is an account's current balance plus the day by day
+// transaction totals it was built from
+type accountHistory struct {
+	Account
+	Since string
+	Days  []dailyTotal
+}
+
+type dailyTotal struct {
+	AccountID int64   `db:"account_id"`
+	Day       string  `db:"day"`
+	Amount    float64 `db:"amount"`
+}
+
+// BalanceAt reconstructs the balance at the end of day. Transactions
+// subtract from the balance, so undoing everything after day adds them back.
+func (h *accountHistory) BalanceAt(day string) float64 {
+	balance := h.Balance
+	for _, d := range h.Days {
+		if d.Day > day {
+			balance += d.Amount
+		}
+	}
+	return balance
+}
+
+func loadAccountHistories() ([]*accountHistory, error) {
+	accounts, err := NewRepository[Account](db, "accounts", "id").List(WithOrderBy("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	var days []dailyTotal
+	err = db.Select(&days, `
+		SELECT account_id, date(date) AS day, SUM(amount) AS amount
+		FROM transactions
+		WHERE is_deleted = 0
+		GROUP BY account_id, day
+		ORDER BY day
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	histories := make([]*accountHistory, 0, len(accounts))
+	byID := map[int64]*accountHistory{}
+	for _, a := range accounts {
+		h := &accountHistory{Account: a, Since: a.CreatedAt.Format(DateLayout)}
+		histories = append(histories, h)
+		byID[a.ID] = h
+	}
+	for _, d := range days {
+		h, ok := byID[d.AccountID]
+		if !ok {
+			continue
+		}
+		h.Days = append(h.Days, d)
+		if d.Day < h.Since {
+			h.Since = d.Day
+		}
+	}
+	return histories, nil
+}
+
+// GetNetWorthReport reconstructs every live account's balance at the end of
+// each period from its transaction history. Accounts only count from their
+// creation or first transaction, whichever is earlier.
+func GetNetWorthReport(rr ReportRange) (*NetWorthReport, error) {
+	histories, err := loadAccountHistories()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &NetWorthReport{ReportRange: rr, Series: []NetWorthPoint{}}
+	for _, p := range Periods(rr) {
+		end := p.End.Format(DateLayout)
+		point := NetWorthPoint{Period: p.Label, Date: end, Accounts: []AccountBalance{}}
+
+		for _, h := range histories {
+			if h.Since > end {
+				continue
+			}
+
+			balance := h.BalanceAt(end)
+			if AccountTypes[h.Type] {
+				point.Liabilities -= balance
+			} else {
+				point.Assets += balance
+			}
+			point.Accounts = append(point.Accounts, AccountBalance{
+				ID:      h.ID,
+				Name:    h.Name,
+				Type:    h.Type,
+				Balance: balance,
+			})
+		}
+
+		point.NetWorth = point.Assets - point.Liabilities
+		report.Series = append(report.Series, point)
+	}
+
+	if rr.Compare == CompareYearOverYear {
+		previous, err := GetNetWorthReport(rr.PreviousYear())
+		if err != nil {
+			return nil, err
+		}
+		// Both ranges have one point per period
+		for i := range min(len(report.Series), len(previous.Series)) {
+			prev := previous.Series[i]
+			report.Series[i].Previous = &prev
+			report.Series[i].Change = PercentChange(report.Series[i].NetWorth, prev.NetWorth)
+		}
+	}
+	return report, nil
+}
+
+// HandleNetWorthReport handles
+// GET /reports/net-worth?from=...&to=...|period=...&bucket=...&compare=...
+func HandleNetWorthReport(w http.ResponseWriter, r *http.Request) {
+	rr, err := ParseReportRange(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	report, err := GetNetWorthReport(rr)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, report)
+}
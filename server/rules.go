@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Rule assigns a category, renames the payee or sets the memo of the
+// transactions matching all of its conditions. Amount bounds compare against
+// the transaction's absolute amount, text conditions ignore case except
+// PayeeRegex, which may opt in with (?i).
+type Rule struct {
+	ID            int64     `json:"id"`
+	Name          string    `json:"name"`
+	Priority      int       `json:"priority"`
+	PayeeContains *string   `db:"payee_contains" json:"payee_contains,omitempty"`
+	PayeeRegex    *string   `db:"payee_regex" json:"payee_regex,omitempty"`
+	MemoContains  *string   `db:"memo_contains" json:"memo_contains,omitempty"`
+	MinAmount     *float64  `db:"min_amount" json:"min_amount,omitempty"`
+	MaxAmount     *float64  `db:"max_amount" json:"max_amount,omitempty"`
+	AccountID     *int64    `db:"account_id" json:"account_id,omitempty"`
+	SetCategoryID *int64    `db:"set_category_id" json:"set_category_id,omitempty"`
+	SetPayee      *string   `db:"set_payee" json:"set_payee,omitempty"`
+	SetMemo       *string   `db:"set_memo" json:"set_memo,omitempty"`
+	CreatedAt     Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt     Timestamp `db:"updated_at" json:"updated_at"`
+	IsDeleted     int       `db:"is_deleted" json:"is_deleted"`
+
+	re *regexp.Regexp
+}
+
+type RuleRequest struct {
+	Name          string   `json:"name"`
+	Priority      int      `json:"priority"`
+	PayeeContains *string  `json:"payee_contains,omitempty"`
+	PayeeRegex    *string  `json:"payee_regex,omitempty"`
+	MemoContains  *string  `json:"memo_contains,omitempty"`
+	MinAmount     *float64 `json:"min_amount,omitempty"`
+	MaxAmount     *float64 `json:"max_amount,omitempty"`
+	AccountID     *int64   `json:"account_id,omitempty"`
+	SetCategoryID *int64   `json:"set_category_id,omitempty"`
+	SetPayee      *string  `json:"set_payee,omitempty"`
+	SetMemo       *string  `json:"set_memo,omitempty"`
+}
+
+// RuleTarget is the part of a transaction rules look at and change
+type RuleTarget struct {
+	AccountID  int64   `json:"-"`
+	CategoryID *int64  `json:"category_id"`
+	Payee      *string `json:"payee"`
+	Memo       *string `json:"memo"`
+	Amount     float64 `json:"-"`
+}
+
+// RuleChange is what re-running the rules did, or would do, to a
+// transaction. Skipped says why a change was left undone.
+type RuleChange struct {
+	TransactionID int64      `json:"transaction_id"`
+	RuleIDs       []int64    `json:"rule_ids"`
+	Before        RuleTarget `json:"before"`
+	After         RuleTarget `json:"after"`
+	Skipped       string     `json:"skipped,omitempty"`
+}
+
+func (r RuleRequest) validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return BadRequest("name is required")
+	}
+	if r.PayeeContains == nil && r.PayeeRegex == nil && r.MemoContains == nil &&
+		r.MinAmount == nil && r.MaxAmount == nil && r.AccountID == nil {
+		return BadRequest("a rule needs at least one condition")
+	}
+	if r.SetCategoryID == nil && r.SetPayee == nil && r.SetMemo == nil {
+		return BadRequest("a rule needs at least one of set_category_id, set_payee and set_memo")
+	}
+	if r.PayeeRegex != nil {
+		if _, err := regexp.Compile(*r.PayeeRegex); err != nil {
+			return BadRequest("invalid payee_regex: %v", err)
+		}
+	}
+	if r.MinAmount != nil && r.MaxAmount != nil && *r.MinAmount > *r.MaxAmount {
+		return BadRequest("min_amount is greater than max_amount")
+	}
+	if r.SetPayee != nil && NormalizePayee(*r.SetPayee) == "" {
+		return BadRequest("set_payee %q has no letters or digits", *r.SetPayee)
+	}
+	if r.SetCategoryID != nil {
+		var alive int
+		if err := db.Get(&alive, `SELECT COUNT(*) FROM categories WHERE id = ? AND is_deleted = 0`, *r.SetCategoryID); err != nil {
+			return err
+		}
+		if alive == 0 {
+			return NotFound("category %d not found", *r.SetCategoryID)
+		}
+	}
+	return nil
+}
+
+func (r RuleRequest) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"name":            strings.TrimSpace(r.Name),
+		"priority":        r.Priority,
+		"payee_contains":  r.PayeeContains,
+		"payee_regex":     r.PayeeRegex,
+		"memo_contains":   r.MemoContains,
+		"min_amount":      r.MinAmount,
+		"max_amount":      r.MaxAmount,
+		"account_id":      r.AccountID,
+		"set_category_id": r.SetCategoryID,
+		"set_payee":       r.SetPayee,
+		"set_memo":        r.SetMemo,
+	}
+}
+
+func containsFold(s *string, sub string) bool {
+	return s != nil && strings.Contains(strings.ToLower(*s), strings.ToLower(sub))
+}
+
+// Matches reports whether every condition of the rule holds for t
+func (r *Rule) Matches(t RuleTarget) bool {
+	if r.AccountID != nil && *r.AccountID != t.AccountID {
+		return false
+	}
+	if r.PayeeContains != nil && !containsFold(t.Payee, *r.PayeeContains) {
+		return false
+	}
+	if r.re != nil && (t.Payee == nil || !r.re.MatchString(*t.Payee)) {
+		return false
+	}
+	if r.MemoContains != nil && !containsFold(t.Memo, *r.MemoContains) {
+		return false
+	}
+
+	amount := math.Abs(t.Amount)
+	if r.MinAmount != nil && amount < *r.MinAmount {
+		return false
+	}
+	if r.MaxAmount != nil && amount > *r.MaxAmount {
+		return false
+	}
+	return true
+}
+
+// LoadRules lists the live rules in the order they are tried
+func LoadRules() ([]Rule, error) {
+	rules, err := NewRepository[Rule](db, "rules", "id").List(WithOrderBy("priority DESC, id"))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rules {
+		if rules[i].PayeeRegex != nil {
+			// Checked when the rule was saved
+			rules[i].re, _ = regexp.Compile(*rules[i].PayeeRegex)
+		}
+	}
+	return rules, nil
+}
+
+// ApplyRules runs the rules over t and returns the IDs of those that changed
+// something. Conditions look at t as it was given. Each field is set by the
+// first matching rule that sets it; categories and memos t already has are
+// only replaced when overwrite is set. A set_payee that isn't a payee name,
+// saved before those were refused, is ignored.
+func ApplyRules(rules []Rule, t *RuleTarget, overwrite bool) []int64 {
+	original := *t
+	var categorySet, payeeSet, memoSet bool
+	applied := []int64{}
+
+	for i := range rules {
+		r := &rules[i]
+		if !r.Matches(original) {
+			continue
+		}
+
+		changed := false
+		if r.SetCategoryID != nil && !categorySet && (original.CategoryID == nil || overwrite) {
+			t.CategoryID, categorySet, changed = r.SetCategoryID, true, true
+		}
+		if r.SetPayee != nil && !payeeSet && NormalizePayee(*r.SetPayee) != "" {
+			t.Payee, payeeSet, changed = r.SetPayee, true, true
+		}
+		if r.SetMemo != nil && !memoSet && (StringValue(original.Memo) == "" || overwrite) {
+			t.Memo, memoSet, changed = r.SetMemo, true, true
+		}
+		if changed {
+			applied = append(applied, r.ID)
+		}
+	}
+	return applied
+}
+
+// PrepareTransaction fills in what a new transaction doesn't say: the rules
+// run first, then the payee's default category supplies a category still
+// missing, and the amount gets its sign. The category may stay nil.
+func PrepareTransaction(q sqlx.Queryer, rules []Rule, req CreaateTransactionRequest) (CreaateTransactionRequest, []int64, error) {
+	t := RuleTarget{
+		AccountID:  req.AccountID,
+		CategoryID: req.CategoryID,
+		Payee:      req.Payee,
+		Memo:       req.Memo,
+		Amount:     req.Amount,
+	}
+	applied := ApplyRules(rules, &t, false)
+	req.CategoryID, req.Payee, req.Memo = t.CategoryID, t.Payee, t.Memo
+
+	if req.CategoryID == nil && StringValue(req.Payee) != "" {
+		categoryID, err := PayeeDefaultCategory(q, *req.Payee)
+		if err != nil {
+			return req, nil, err
+		}
+		req.CategoryID = categoryID
+	}
+
+	amount, err := SignedAmount(q, req.CategoryID, req.Direction, req.Amount)
+	if err != nil {
+		return req, nil, err
+	}
+	req.Amount = amount
+	return req, applied, nil
+}
+
+// RunRules re-runs the rules over every live transaction except transfers.
+// Recategorized transactions move their amount between categories. A rule
+// can't move a transaction into a deleted category, or between an income
+// and a spending category; those changes are reported as skipped. With
+// dryRun nothing is written and the changes are only reported.
+func RunRules(dryRun, overwrite bool) ([]RuleChange, error) {
+	rules, err := LoadRules()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var txs []Transaction
+	err = tx.Select(&txs, `
+		SELECT * FROM transactions
+		WHERE is_deleted = 0 AND transfer_account_id IS NULL
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []struct {
+		ID        int64 `db:"id"`
+		IsIncome  bool  `db:"is_income"`
+		IsDeleted int   `db:"is_deleted"`
+	}
+	if err := tx.Select(&categories, `SELECT id, is_income, is_deleted FROM categories`); err != nil {
+		return nil, err
+	}
+	isIncome, deleted := map[int64]bool{}, map[int64]bool{}
+	for _, c := range categories {
+		isIncome[c.ID], deleted[c.ID] = c.IsIncome, c.IsDeleted == 1
+	}
+
+	changes := []RuleChange{}
+	changed := 0
+	for i := range txs {
+		t := &txs[i]
+		before := RuleTarget{AccountID: t.AccountID, CategoryID: t.CategoryID, Payee: t.Payee, Memo: t.Memo, Amount: t.Amount}
+		after := before
+		applied := ApplyRules(rules, &after, overwrite)
+
+		categoryChanged := !sameID(before.CategoryID, after.CategoryID)
+		if !categoryChanged && StringValue(before.Payee) == StringValue(after.Payee) &&
+			StringValue(before.Memo) == StringValue(after.Memo) {
+			continue
+		}
+
+		change := RuleChange{TransactionID: t.ID, RuleIDs: applied, Before: before, After: after}
+		if categoryChanged && after.CategoryID != nil {
+			switch to := *after.CategoryID; {
+			case deleted[to]:
+				change.Skipped = fmt.Sprintf("category %d is deleted", to)
+			case before.CategoryID != nil && isIncome[*before.CategoryID] != isIncome[to]:
+				change.Skipped = "can't move a transaction between an income and a spending category"
+			}
+		}
+		changes = append(changes, change)
+		if dryRun || change.Skipped != "" {
+			continue
+		}
+		changed++
+
+		if categoryChanged {
+			if err := applyTransactionEffect(tx, t, -1); err != nil {
+				return nil, err
+			}
+			t.CategoryID = after.CategoryID
+			if err := applyTransactionEffect(tx, t, 1); err != nil {
+				return nil, err
+			}
+		}
+
+		payeeID := t.PayeeID
+		if StringValue(before.Payee) != StringValue(after.Payee) {
+			p, err := ResolvePayee(tx, *after.Payee)
+			if err != nil {
+				return nil, err
+			}
+			payeeID, after.Payee = &p.ID, &p.Name
+		}
+
+		_, err = tx.Exec(`
+			UPDATE transactions
+			SET category_id = ?, payee = ?, payee_id = ?, memo = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, after.CategoryID, after.Payee, payeeID, after.Memo, t.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return changes, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[DB][OK] run_rules(overwrite=%t) changed=%d skipped=%d\n", overwrite, changed, len(changes)-changed)
+	return changes, nil
+}
+
+func sameID(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// HandleGetRules handles GET /rules
+func HandleGetRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := LoadRules()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if rules == nil {
+		rules = []Rule{}
+	}
+
+	WriteJSON(w, rules)
+}
+
+// HandleCreateRule handles POST /rules
+func HandleCreateRule(w http.ResponseWriter, r *http.Request) {
+	var req RuleRequest
+
+	HandleCreate(
+		w,
+		r,
+		&req,
+		nil,
+		func(r RuleRequest) (int64, error) {
+			if err := r.validate(); err != nil {
+				return 0, err
+			}
+
+			id, err := NewRepository[Rule](db, "rules", "id").Create(r.fields())
+			if err != nil {
+				return 0, err
+			}
+
+			log.Printf("[DB][OK] create_rule(id=%d, name=%s, priority=%d)\n", id, r.Name, r.Priority)
+			return id, nil
+		},
+	)
+}
+
+// HandleUpdateRule handles PUT /rules/{id}, replacing the whole rule
+func HandleUpdateRule(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	var req RuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	repo := NewRepository[Rule](db, "rules", "id")
+	if _, err := repo.GetByID(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := repo.Update(id, req.fields()); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
+
+// HandleDeleteRule handles DELETE /rules/{id}
+func HandleDeleteRule(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	repo := NewRepository[Rule](db, "rules", "id")
+	if _, err := repo.GetByID(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := repo.Delete(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
+
+// HandleRunRules handles POST /rules/run?dry_run=true&overwrite=true
+func HandleRunRules(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var dryRun, overwrite bool
+	var err error
+	if v := q.Get("dry_run"); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid dry_run", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("overwrite"); v != "" {
+		if overwrite, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid overwrite", http.StatusBadRequest)
+			return
+		}
+	}
+
+	changes, err := RunRules(dryRun, overwrite)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"status":  "OK",
+		"dry_run": dryRun,
+		"changes": changes,
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import "testing"
+
+func TestRunRulesCategories(t *testing.T) {
+	tests := []struct {
+		name string
+		// from is the transaction's category, to the one the rule sets
+		from, to    func(l ledger) *int64
+		amount      float64
+		deleteTo    bool
+		wantSkipped bool
+		want        func(l ledger) map[int64]float64
+	}{
+		{
+			name:   "between spending categories",
+			from:   func(l ledger) *int64 { return &l.food },
+			to:     func(l ledger) *int64 { return &l.transport },
+			amount: 30,
+			want: func(l ledger) map[int64]float64 {
+				return map[int64]float64{l.food: 100, l.transport: 70, l.salary: 100}
+			},
+		},
+		{
+			name:        "spending into income",
+			from:        func(l ledger) *int64 { return &l.food },
+			to:          func(l ledger) *int64 { return &l.salary },
+			amount:      30,
+			wantSkipped: true,
+			want: func(l ledger) map[int64]float64 {
+				return map[int64]float64{l.food: 70, l.transport: 100, l.salary: 100}
+			},
+		},
+		{
+			name:        "into a deleted category",
+			from:        func(l ledger) *int64 { return &l.food },
+			to:          func(l ledger) *int64 { return &l.transport },
+			amount:      30,
+			deleteTo:    true,
+			wantSkipped: true,
+			want: func(l ledger) map[int64]float64 {
+				return map[int64]float64{l.food: 70, l.transport: 100, l.salary: 100}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLedger(t)
+
+			payee := "Shop"
+			id, err := CreateTransaction(l.account, tt.from(l), &payee, nil, tt.amount, "2026-01-15")
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustExec(t, `INSERT INTO rules (name, payee_contains, set_category_id) VALUES ('Shop', 'shop', ?)`, *tt.to(l))
+			if tt.deleteTo {
+				mustExec(t, `UPDATE categories SET is_deleted = 1 WHERE id = ?`, *tt.to(l))
+			}
+
+			changes, err := RunRules(false, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != 1 || changes[0].TransactionID != id {
+				t.Fatalf("changes = %+v", changes)
+			}
+			if skipped := changes[0].Skipped != ""; skipped != tt.wantSkipped {
+				t.Errorf("skipped = %q, want skipped %t", changes[0].Skipped, tt.wantSkipped)
+			}
+
+			b := loadBalances(t)
+			if got, want := b.Accounts[l.account], 1000-tt.amount; got != want {
+				t.Errorf("account balance = %v, want %v", got, want)
+			}
+			for category, want := range tt.want(l) {
+				if got := b.Categories[category]; got != want {
+					t.Errorf("category %d amount = %v, want %v", category, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunRulesSetPayeeWithoutName(t *testing.T) {
+	l := newLedger(t)
+
+	payee := "Shop"
+	id, err := CreateTransaction(l.account, &l.food, &payee, nil, 30, "2026-01-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, `INSERT INTO rules (name, payee_contains, set_payee, priority) VALUES ('Blank', 'shop', '--', 1)`)
+	mustExec(t, `INSERT INTO rules (name, payee_contains, set_memo) VALUES ('Memo', 'shop', 'groceries')`)
+
+	changes, err := RunRules(false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || StringValue(changes[0].After.Payee) != payee || StringValue(changes[0].After.Memo) != "groceries" {
+		t.Fatalf("changes = %+v", changes)
+	}
+
+	var memo string
+	if err := db.Get(&memo, `SELECT memo FROM transactions WHERE id = ?`, id); err != nil {
+		t.Fatal(err)
+	}
+	if memo != "groceries" {
+		t.Errorf("memo = %q, want groceries", memo)
+	}
+}
@@ -0,0 +1,691 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SchedulerInterval is how often the background scheduler posts due
+// transactions
+const SchedulerInterval = time.Hour
+
+// DefaultUpcomingDays is the window of GET /schedules/upcoming
+const DefaultUpcomingDays = 30
+
+const (
+	ExceptionSkip     = "skip"
+	ExceptionPostpone = "postpone"
+)
+
+// Schedule is a recurring transaction. Occurrences counts the occurrences
+// already handled, posted or skipped; NextDate is the next one, nil once the
+// schedule has ended.
+type Schedule struct {
+	ID          int64     `json:"id"`
+	AccountID   int64     `db:"account_id" json:"account_id"`
+	CategoryID  *int64    `db:"category_id" json:"category_id,omitempty"`
+	Payee       *string   `json:"payee,omitempty"`
+	Memo        *string   `json:"memo,omitempty"`
+	Amount      float64   `json:"amount"`
+	Frequency   string    `json:"frequency"`
+	Interval    int       `json:"interval"`
+	WeekOfMonth *int      `db:"week_of_month" json:"week_of_month,omitempty"`
+	Weekday     *int      `json:"weekday,omitempty"`
+	StartDate   string    `db:"start_date" json:"start_date"`
+	EndDate     *string   `db:"end_date" json:"end_date,omitempty"`
+	MaxCount    *int      `db:"max_count" json:"max_count,omitempty"`
+	Occurrences int       `json:"occurrences"`
+	NextDate    *string   `db:"next_date" json:"next_date"`
+	CreatedAt   Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt   Timestamp `db:"updated_at" json:"updated_at"`
+	IsDeleted   int       `db:"is_deleted" json:"is_deleted"`
+}
+
+// ScheduleException skips or postpones one occurrence of a schedule
+type ScheduleException struct {
+	ID            int64     `json:"id"`
+	ScheduleID    int64     `db:"schedule_id" json:"schedule_id"`
+	Date          string    `json:"date"`
+	Action        string    `json:"action"`
+	NewDate       *string   `db:"new_date" json:"new_date,omitempty"`
+	TransactionID *int64    `db:"transaction_id" json:"transaction_id,omitempty"`
+	CreatedAt     Timestamp `db:"created_at" json:"created_at"`
+}
+
+type CreateScheduleRequest struct {
+	AccountID   int64   `json:"account_id"`
+	CategoryID  *int64  `json:"category_id,omitempty"`
+	Payee       *string `json:"payee,omitempty"`
+	Memo        *string `json:"memo,omitempty"`
+	Amount      float64 `json:"amount"`
+	Direction   string  `json:"direction,omitempty"`
+	Frequency   string  `json:"frequency"`
+	Interval    int     `json:"interval,omitempty"`
+	WeekOfMonth *int    `json:"week_of_month,omitempty"`
+	Weekday     *int    `json:"weekday,omitempty"`
+	StartDate   string  `json:"start_date"`
+	EndDate     *string `json:"end_date,omitempty"`
+	MaxCount    *int    `json:"max_count,omitempty"`
+}
+
+type ScheduleExceptionRequest struct {
+	Date    string `json:"date"`
+	NewDate string `json:"new_date,omitempty"`
+}
+
+// Occurrence is one upcoming transaction of a schedule. OriginalDate differs
+// from Date when the occurrence was postponed.
+type Occurrence struct {
+	ScheduleID   int64   `json:"schedule_id"`
+	Date         string  `json:"date"`
+	OriginalDate string  `json:"original_date"`
+	AccountID    int64   `json:"account_id"`
+	CategoryID   *int64  `json:"category_id,omitempty"`
+	Payee        *string `json:"payee,omitempty"`
+	Memo         *string `json:"memo,omitempty"`
+	Amount       float64 `json:"amount"`
+	Postponed    bool    `json:"postponed"`
+}
+
+func parseDate(s string) (time.Time, error) {
+	return time.Parse(DateLayout, s)
+}
+
+// nthWeekday is the week-th weekday of a month, or its last one for week -1
+func nthWeekday(year int, month time.Month, week, weekday int) time.Time {
+	if week < 0 {
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+		return last.AddDate(0, 0, -((int(last.Weekday()) - weekday + 7) % 7))
+	}
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return first.AddDate(0, 0, (weekday-int(first.Weekday())+7)%7+(week-1)*7)
+}
+
+// addMonthsClamped moves months forward keeping day, or the month's last day
+// when it is shorter, so the 31st stays on month ends instead of drifting
+func addMonthsClamped(t time.Time, months, day int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, time.UTC)
+}
+
+func (s *Schedule) rawOccurrence(n int) time.Time {
+	start, _ := parseDate(s.StartDate)
+	step := n * s.Interval
+
+	switch s.Frequency {
+	case "daily":
+		return start.AddDate(0, 0, step)
+	case "weekly":
+		return start.AddDate(0, 0, 7*step)
+	case "monthly":
+		if s.WeekOfMonth != nil && s.Weekday != nil {
+			month := time.Date(start.Year(), start.Month()+time.Month(step), 1, 0, 0, 0, 0, time.UTC)
+			return nthWeekday(month.Year(), month.Month(), *s.WeekOfMonth, *s.Weekday)
+		}
+		return addMonthsClamped(start, step, start.Day())
+	default:
+		return addMonthsClamped(start, 12*step, start.Day())
+	}
+}
+
+// Occurrence returns the nth (0-based) original occurrence date, false once
+// the schedule has ended by count or end date
+func (s *Schedule) Occurrence(n int) (string, bool) {
+	if s.MaxCount != nil && n >= *s.MaxCount {
+		return "", false
+	}
+
+	// The nth weekday of the start month may fall before the start date, in
+	// which case the series begins a month later
+	start, _ := parseDate(s.StartDate)
+	if s.rawOccurrence(0).Before(start) {
+		n++
+	}
+
+	date := s.rawOccurrence(n).Format(DateLayout)
+	if s.EndDate != nil && date > *s.EndDate {
+		return "", false
+	}
+	return date, true
+}
+
+func (s *Schedule) validate() error {
+	switch s.Frequency {
+	case "daily", "weekly", "monthly", "yearly":
+	default:
+		return BadRequest("frequency must be one of daily, weekly, monthly, yearly")
+	}
+	if s.Interval < 1 {
+		return BadRequest("interval must be at least 1")
+	}
+	if _, err := parseDate(s.StartDate); err != nil {
+		return BadRequest("start_date must be a YYYY-MM-DD date")
+	}
+	if s.EndDate != nil {
+		if _, err := parseDate(*s.EndDate); err != nil {
+			return BadRequest("end_date must be a YYYY-MM-DD date")
+		}
+		if *s.EndDate < s.StartDate {
+			return BadRequest("end_date must not be before start_date")
+		}
+	}
+	if s.MaxCount != nil && *s.MaxCount < 1 {
+		return BadRequest("max_count must be at least 1")
+	}
+	if (s.WeekOfMonth == nil) != (s.Weekday == nil) {
+		return BadRequest("week_of_month and weekday go together")
+	}
+	if s.WeekOfMonth != nil {
+		if s.Frequency != "monthly" {
+			return BadRequest("week_of_month only applies to monthly schedules")
+		}
+		if w := *s.WeekOfMonth; w != -1 && (w < 1 || w > 4) {
+			return BadRequest("week_of_month must be 1-4, or -1 for the last week")
+		}
+		if d := *s.Weekday; d < 0 || d > 6 {
+			return BadRequest("weekday must be 0 (Sunday) to 6 (Saturday)")
+		}
+	}
+	return nil
+}
+
+// CreateSchedule stores a new recurring transaction on a live account and,
+// when it has one, a live category
+func CreateSchedule(req CreateScheduleRequest) (int64, error) {
+	var alive int
+	if err := db.Get(&alive, `SELECT COUNT(*) FROM accounts WHERE id = ? AND is_deleted = 0`, req.AccountID); err != nil {
+		return 0, err
+	}
+	if alive == 0 {
+		return 0, NotFound("account %d not found", req.AccountID)
+	}
+	if req.CategoryID != nil {
+		if err := db.Get(&alive, `SELECT COUNT(*) FROM categories WHERE id = ? AND is_deleted = 0`, *req.CategoryID); err != nil {
+			return 0, err
+		}
+		if alive == 0 {
+			return 0, NotFound("category %d not found", *req.CategoryID)
+		}
+	}
+
+	amount, err := SignedAmount(db, req.CategoryID, req.Direction, req.Amount)
+	if err != nil {
+		return 0, err
+	}
+
+	s := Schedule{
+		AccountID:   req.AccountID,
+		CategoryID:  req.CategoryID,
+		Payee:       req.Payee,
+		Memo:        req.Memo,
+		Amount:      amount,
+		Frequency:   req.Frequency,
+		Interval:    req.Interval,
+		WeekOfMonth: req.WeekOfMonth,
+		Weekday:     req.Weekday,
+		StartDate:   req.StartDate,
+		EndDate:     req.EndDate,
+		MaxCount:    req.MaxCount,
+	}
+	if s.Interval == 0 {
+		s.Interval = 1
+	}
+	if err := s.validate(); err != nil {
+		return 0, err
+	}
+
+	var next *string
+	if date, ok := s.Occurrence(0); ok {
+		next = &date
+	}
+
+	repo := NewRepository[Schedule](db, "schedules", "id")
+	id, err := repo.Create(map[string]interface{}{
+		"account_id":    s.AccountID,
+		"category_id":   s.CategoryID,
+		"payee":         s.Payee,
+		"memo":          s.Memo,
+		"amount":        s.Amount,
+		"frequency":     s.Frequency,
+		"interval":      s.Interval,
+		"week_of_month": s.WeekOfMonth,
+		"weekday":       s.Weekday,
+		"start_date":    s.StartDate,
+		"end_date":      s.EndDate,
+		"max_count":     s.MaxCount,
+		"next_date":     next,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("[DB][OK] create_schedule(id=%d, frequency=%s, next_date=%v)\n", id, s.Frequency, StringValue(next))
+	return id, nil
+}
+
+func loadScheduleExceptions(scheduleID int64) (map[string]ScheduleException, error) {
+	var list []ScheduleException
+	err := db.Select(&list, `SELECT * FROM schedule_exceptions WHERE schedule_id = ?`, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	byDate := make(map[string]ScheduleException, len(list))
+	for _, e := range list {
+		byDate[e.Date] = e
+	}
+	return byDate, nil
+}
+
+// postOccurrence creates a schedule's transaction within tx and links it
+// back to the schedule
+func postOccurrence(tx *sqlx.Tx, s *Schedule, date string) (int64, error) {
+	id, err := InsertTransaction(tx, s.AccountID, s.CategoryID, s.Payee, s.Memo, s.Amount, date)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE transactions SET schedule_id = ? WHERE id = ?`, s.ID, id)
+	return id, err
+}
+
+// advanceSchedule handles the schedule's next occurrence, posting it unless
+// an exception skips or postpones it. The transaction and the schedule's new
+// position are committed together, so an occurrence is never posted twice.
+func advanceSchedule(s *Schedule, date string, excepted bool) (*int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var posted *int64
+	if !excepted {
+		id, err := postOccurrence(tx, s, date)
+		if err != nil {
+			return nil, err
+		}
+		posted = &id
+	}
+
+	occurrences := s.Occurrences + 1
+	var next *string
+	if d, ok := s.Occurrence(occurrences); ok {
+		next = &d
+	}
+	_, err = tx.Exec(`
+		UPDATE schedules
+		SET occurrences = ?, next_date = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, occurrences, next, s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.Occurrences = occurrences
+	return posted, nil
+}
+
+// postPostponed posts a postponed occurrence and records it on its exception
+// in one transaction
+func postPostponed(s *Schedule, e ScheduleException) (int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := postOccurrence(tx, s, *e.NewDate)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE schedule_exceptions SET transaction_id = ? WHERE id = ?`, id, e.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+var schedulerMu sync.Mutex
+
+// liveScheduleTargets keeps the schedules whose account and category are
+// live. The others wait, due occurrences and all, until those are restored.
+const liveScheduleTargets = `
+	s.account_id IN (SELECT id FROM accounts WHERE is_deleted = 0)
+	AND (s.category_id IS NULL OR s.category_id IN (SELECT id FROM categories WHERE is_deleted = 0))`
+
+// MaterializeSchedules posts every occurrence due on or before today through
+// InsertTransaction, then the postponed occurrences that have come due.
+// Schedules of deleted accounts or categories are left alone.
+// It returns the IDs of the transactions it created.
+func MaterializeSchedules(today string) ([]int64, error) {
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+
+	var schedules []Schedule
+	err := db.Select(&schedules, `
+		SELECT s.* FROM schedules s
+		WHERE s.is_deleted = 0 AND s.next_date IS NOT NULL AND s.next_date <= ? AND `+liveScheduleTargets+`
+		ORDER BY s.id
+	`, today)
+	if err != nil {
+		return nil, err
+	}
+
+	created := []int64{}
+	for i := range schedules {
+		s := &schedules[i]
+		exceptions, err := loadScheduleExceptions(s.ID)
+		if err != nil {
+			return created, err
+		}
+
+		for {
+			date, ok := s.Occurrence(s.Occurrences)
+			if !ok || date > today {
+				break
+			}
+
+			_, excepted := exceptions[date]
+			id, err := advanceSchedule(s, date, excepted)
+			if err != nil {
+				return created, err
+			}
+			if id != nil {
+				created = append(created, *id)
+			}
+		}
+	}
+
+	var postponed []ScheduleException
+	err = db.Select(&postponed, `
+		SELECT e.* FROM schedule_exceptions e
+		JOIN schedules s ON s.id = e.schedule_id
+		WHERE e.action = ? AND e.transaction_id IS NULL AND e.new_date <= ? AND s.is_deleted = 0
+		  AND `+liveScheduleTargets+`
+	`, ExceptionPostpone, today)
+	if err != nil {
+		return created, err
+	}
+
+	for _, p := range postponed {
+		s, err := NewRepository[Schedule](db, "schedules", "id").GetByID(p.ScheduleID)
+		if err != nil {
+			return created, err
+		}
+
+		id, err := postPostponed(s, p)
+		if err != nil {
+			return created, err
+		}
+		created = append(created, id)
+	}
+
+	if len(created) > 0 {
+		log.Printf("[SCHED][OK] materialized %d transactions up to %s\n", len(created), today)
+	}
+	return created, nil
+}
+
+// RunScheduler materializes due schedules now and then every interval
+func RunScheduler(interval time.Duration) {
+	for {
+		if _, err := MaterializeSchedules(Today().Format(DateLayout)); err != nil {
+			log.Printf("[SCHED][ERROR] %v\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// UpcomingOccurrences lists the occurrences of every live schedule from
+// today through today + days, in date order, with skips left out and
+// postponed occurrences at their new date
+func UpcomingOccurrences(today string, days int) ([]Occurrence, error) {
+	t, err := parseDate(today)
+	if err != nil {
+		return nil, err
+	}
+	until := t.AddDate(0, 0, days).Format(DateLayout)
+
+	schedules, err := NewRepository[Schedule](db, "schedules", "id").List()
+	if err != nil {
+		return nil, err
+	}
+
+	upcoming := []Occurrence{}
+	for i := range schedules {
+		s := &schedules[i]
+		exceptions, err := loadScheduleExceptions(s.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		occurrence := func(date, original string) Occurrence {
+			return Occurrence{
+				ScheduleID:   s.ID,
+				Date:         date,
+				OriginalDate: original,
+				AccountID:    s.AccountID,
+				CategoryID:   s.CategoryID,
+				Payee:        s.Payee,
+				Memo:         s.Memo,
+				Amount:       s.Amount,
+				Postponed:    date != original,
+			}
+		}
+
+		for n := s.Occurrences; ; n++ {
+			date, ok := s.Occurrence(n)
+			if !ok || date > until {
+				break
+			}
+			if _, excepted := exceptions[date]; !excepted {
+				upcoming = append(upcoming, occurrence(date, date))
+			}
+		}
+
+		for _, e := range exceptions {
+			if e.Action == ExceptionPostpone && e.TransactionID == nil && *e.NewDate <= until {
+				upcoming = append(upcoming, occurrence(*e.NewDate, e.Date))
+			}
+		}
+	}
+
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		if upcoming[i].Date != upcoming[j].Date {
+			return upcoming[i].Date < upcoming[j].Date
+		}
+		return upcoming[i].ScheduleID < upcoming[j].ScheduleID
+	})
+	return upcoming, nil
+}
+
+// AddScheduleException skips the occurrence on date, or postpones it to
+// newDate. date must be an occurrence that hasn't been posted yet.
+func AddScheduleException(scheduleID int64, action, date, newDate string) error {
+	s, err := NewRepository[Schedule](db, "schedules", "id").GetByID(scheduleID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := parseDate(date); err != nil {
+		return BadRequest("date must be a YYYY-MM-DD date")
+	}
+
+	pending := false
+	for n := s.Occurrences; ; n++ {
+		d, ok := s.Occurrence(n)
+		if !ok || d > date {
+			break
+		}
+		if d == date {
+			pending = true
+			break
+		}
+	}
+	if !pending {
+		return BadRequest("%s is not an upcoming occurrence of schedule %d", date, scheduleID)
+	}
+
+	var target *string
+	if action == ExceptionPostpone {
+		if _, err := parseDate(newDate); err != nil {
+			return BadRequest("new_date must be a YYYY-MM-DD date")
+		}
+		if newDate <= date {
+			return BadRequest("new_date must be after date")
+		}
+		target = &newDate
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO schedule_exceptions (schedule_id, date, action, new_date)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (schedule_id, date) DO UPDATE
+		SET action = excluded.action, new_date = excluded.new_date
+	`, scheduleID, date, action, target)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[DB][OK] schedule_exception(schedule_id=%d, action=%s, date=%s, new_date=%s)\n",
+		scheduleID, action, date, StringValue(target))
+	return nil
+}
+
+// HandleCreateSchedule handles POST /schedules
+func HandleCreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var req CreateScheduleRequest
+
+	HandleCreate(
+		w,
+		r,
+		&req,
+		func(r CreateScheduleRequest) error {
+			if r.AccountID == 0 {
+				return BadRequest("account id is required")
+			}
+			return nil
+		},
+		CreateSchedule,
+	)
+}
+
+// HandleGetSchedules handles GET /schedules
+func HandleGetSchedules(w http.ResponseWriter, r *http.Request) {
+	schedules, err := NewRepository[Schedule](db, "schedules", "id").List(WithOrderBy("next_date IS NULL, next_date"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if schedules == nil {
+		schedules = []Schedule{}
+	}
+
+	WriteJSON(w, schedules)
+}
+
+// HandleDeleteSchedule handles DELETE /schedules/{id}
+func HandleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	repo := NewRepository[Schedule](db, "schedules", "id")
+	if _, err := repo.GetByID(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := repo.Delete(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
+
+// HandleUpcomingSchedules handles GET /schedules/upcoming?days=N
+func HandleUpcomingSchedules(w http.ResponseWriter, r *http.Request) {
+	days := DefaultUpcomingDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		var err error
+		if days, err = strconv.Atoi(v); err != nil || days < 0 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+	}
+
+	upcoming, err := UpcomingOccurrences(Today().Format(DateLayout), days)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, upcoming)
+}
+
+// HandleRunSchedules handles POST /schedules/run, posting due occurrences
+// without waiting for the background scheduler
+func HandleRunSchedules(w http.ResponseWriter, r *http.Request) {
+	created, err := MaterializeSchedules(Today().Format(DateLayout))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"status":       "OK",
+		"transactions": created,
+	})
+}
+
+func handleScheduleException(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := PathID(r, "id")
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+
+		var req ScheduleExceptionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if err := AddScheduleException(id, action, req.Date, req.NewDate); err != nil {
+			WriteError(w, err)
+			return
+		}
+
+		WriteJSON(w, map[string]string{
+			"status": "OK",
+		})
+	}
+}
+
+// HandleSkipOccurrence handles POST /schedules/{id}/skip
+var HandleSkipOccurrence = handleScheduleException(ExceptionSkip)
+
+// HandlePostponeOccurrence handles POST /schedules/{id}/postpone
+var HandlePostponeOccurrence = handleScheduleException(ExceptionPostpone)
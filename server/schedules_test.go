@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestCreateScheduleReferences(t *testing.T) {
+	l := newLedger(t)
+	deletedAccount := mustExec(t, `INSERT INTO accounts (name, type, balance, is_deleted) VALUES ('Old', 'checking', 0, 1)`)
+	deletedCategory := mustExec(t, `INSERT INTO categories (name, amount, is_deleted) VALUES ('Old', 0, 1)`)
+	missing := int64(999)
+
+	tests := []struct {
+		name       string
+		accountID  int64
+		categoryID *int64
+		status     int
+	}{
+		{"live account and category", l.account, &l.food, 0},
+		{"no category", l.account, nil, 0},
+		{"missing account", missing, &l.food, http.StatusNotFound},
+		{"deleted account", deletedAccount, nil, http.StatusNotFound},
+		{"missing category", l.account, &missing, http.StatusNotFound},
+		{"deleted category", l.account, &deletedCategory, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CreateSchedule(CreateScheduleRequest{
+				AccountID:  tt.accountID,
+				CategoryID: tt.categoryID,
+				Amount:     50,
+				Frequency:  "monthly",
+				StartDate:  "2026-01-15",
+			})
+
+			var httpErr *HTTPError
+			if tt.status != 0 {
+				if !errors.As(err, &httpErr) || httpErr.Status != tt.status {
+					t.Fatalf("err = %v, want status %d", err, tt.status)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultSearchLimit is how many results GET /search returns when the
+// request doesn't say
+const DefaultSearchLimit = 50
+
+// SearchResult is a transaction matching a search. Highlights hold each
+// indexed field as HTML with the matches wrapped in <mark>, Snippet the best
+// matching fragment. Lower ranks are better matches.
+type SearchResult struct {
+	TransactionWithRelations
+	Rank       float64           `db:"rank" json:"rank"`
+	Snippet    string            `db:"snippet" json:"snippet"`
+	Highlights map[string]string `db:"-" json:"highlights"`
+
+	PayeeHighlight    *string `db:"payee_hl" json:"-"`
+	MemoHighlight     *string `db:"memo_hl" json:"-"`
+	CategoryHighlight *string `db:"category_hl" json:"-"`
+	AccountHighlight  *string `db:"account_hl" json:"-"`
+}
+
+// searchTriggers keep the search index in step with transactions, as
+// migration 015 first created them
+var searchTriggers = map[string]string{
+	"transactions_fts_insert": `
+		CREATE TRIGGER transactions_fts_insert AFTER INSERT ON transactions
+		WHEN new.is_deleted = 0
+		BEGIN
+			INSERT INTO transactions_fts (rowid, payee, memo, category, account)
+			VALUES (
+				new.id,
+				new.payee,
+				new.memo,
+				(SELECT name FROM categories WHERE id = new.category_id),
+				(SELECT name FROM accounts WHERE id = new.account_id)
+			);
+		END`,
+	"transactions_fts_update": `
+		CREATE TRIGGER transactions_fts_update AFTER UPDATE ON transactions
+		BEGIN
+			DELETE FROM transactions_fts WHERE rowid = old.id;
+			INSERT INTO transactions_fts (rowid, payee, memo, category, account)
+			SELECT
+				new.id,
+				new.payee,
+				new.memo,
+				(SELECT name FROM categories WHERE id = new.category_id),
+				(SELECT name FROM accounts WHERE id = new.account_id)
+			WHERE new.is_deleted = 0;
+		END`,
+	"transactions_fts_delete": `
+		CREATE TRIGGER transactions_fts_delete AFTER DELETE ON transactions
+		BEGIN
+			DELETE FROM transactions_fts WHERE rowid = old.id;
+		END`,
+	"transactions_fts_category_rename": `
+		CREATE TRIGGER transactions_fts_category_rename AFTER UPDATE OF name ON categories
+		BEGIN
+			UPDATE transactions_fts SET category = new.name
+			WHERE rowid IN (SELECT id FROM transactions WHERE category_id = new.id);
+		END`,
+	"transactions_fts_account_rename": `
+		CREATE TRIGGER transactions_fts_account_rename AFTER UPDATE OF name ON accounts
+		BEGIN
+			UPDATE transactions_fts SET account = new.name
+			WHERE rowid IN (SELECT id FROM transactions WHERE account_id = new.id);
+		END`,
+}
+
+// SetupSearch reports whether full-text search can be served. It needs the
+// index of migration 015 and SQLite with FTS5 (-tags sqlite_fts5). Without
+// FTS5 the index triggers would make every write to transactions fail, so
+// they are dropped and search is off; the next start with FTS5 rebuilds the
+// index and brings them back.
+func SetupSearch() (bool, error) {
+	var indexed, fts5 int
+	err := db.Get(&indexed, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'transactions_fts'`)
+	if err != nil {
+		return false, err
+	}
+	if err := db.Get(&fts5, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`); err != nil {
+		return false, err
+	}
+	if indexed == 0 {
+		return false, nil
+	}
+
+	var triggers []string
+	err = db.Select(&triggers, `SELECT name FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'transactions_fts_%'`)
+	if err != nil {
+		return false, err
+	}
+
+	if fts5 == 0 {
+		for _, name := range triggers {
+			if _, err := db.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+				return false, err
+			}
+		}
+		log.Printf("[DB] search disabled: SQLite was built without FTS5, build with -tags sqlite_fts5\n")
+		return false, nil
+	}
+	if len(triggers) == len(searchTriggers) {
+		return true, nil
+	}
+
+	// Transactions changed unindexed while the triggers were gone
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	for _, name := range triggers {
+		if _, err := tx.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM transactions_fts`); err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO transactions_fts (rowid, payee, memo, category, account)
+		SELECT t.id, t.payee, t.memo, c.name, a.name
+		FROM transactions t
+		LEFT JOIN categories c ON c.id = t.category_id
+		LEFT JOIN accounts a ON a.id = t.account_id
+		WHERE t.is_deleted = 0
+	`)
+	if err != nil {
+		return false, err
+	}
+	for _, trigger := range searchTriggers {
+		if _, err := tx.Exec(trigger); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	log.Printf("[DB][OK] rebuild_search_index()\n")
+	return true, nil
+}
+
+var markHighlights = strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>")
+
+// highlightHTML turns text in which FTS5 delimited the matches with \x02 and
+// \x03 into HTML: the text is escaped, the matches wrapped in <mark>
+func highlightHTML(s string) string {
+	return markHighlights.Replace(html.EscapeString(s))
+}
+
+// SearchQuery turns user input into an FTS5 query: every word must match,
+// as a prefix, in any of the indexed fields. FTS5 syntax in the input is
+// taken literally.
+func SearchQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, len(words))
+	for i, w := range words {
+		terms[i] = `"` + w + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search finds live transactions by payee, memo, category and account
+// name, best matches first. Payee matches weigh most, then category.
+func Search(q string, limit, offset int) ([]SearchResult, error) {
+	results := []SearchResult{}
+	query := SearchQuery(q)
+	if query == "" {
+		return results, nil
+	}
+
+	err := db.Select(&results, `
+		SELECT
+			t.*,
+			CASE WHEN t.amount < 0 THEN 'inflow' ELSE 'outflow' END AS direction,
+			a.name AS account_name,
+			c.name AS category_name,
+			bm25(transactions_fts, 3.0, 1.0, 2.0, 1.0) AS rank,
+			snippet(transactions_fts, -1, char(2), char(3), '…', 12) AS snippet,
+			highlight(transactions_fts, 0, char(2), char(3)) AS payee_hl,
+			highlight(transactions_fts, 1, char(2), char(3)) AS memo_hl,
+			highlight(transactions_fts, 2, char(2), char(3)) AS category_hl,
+			highlight(transactions_fts, 3, char(2), char(3)) AS account_hl
+		FROM transactions_fts
+		JOIN transactions t ON t.id = transactions_fts.rowid
+		JOIN accounts a ON a.id = t.account_id
+		LEFT JOIN categories c ON c.id = t.category_id
+		WHERE transactions_fts MATCH ? AND t.is_deleted = 0
+		ORDER BY rank, t.id DESC
+		LIMIT ? OFFSET ?
+	`, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(results))
+	for i := range results {
+		ids[i] = results[i].ID
+	}
+	tags, err := LoadTransactionTags(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		r := &results[i]
+		r.Tags = tags[r.ID]
+		if r.Tags == nil {
+			r.Tags = []string{}
+		}
+		r.Snippet = highlightHTML(r.Snippet)
+		r.Highlights = map[string]string{
+			"payee":    highlightHTML(StringValue(r.PayeeHighlight)),
+			"memo":     highlightHTML(StringValue(r.MemoHighlight)),
+			"category": highlightHTML(StringValue(r.CategoryHighlight)),
+			"account":  highlightHTML(StringValue(r.AccountHighlight)),
+		}
+	}
+	return results, nil
+}
+
+// HandleSearch handles GET /search?q=...&limit=N&offset=N
+func HandleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit := DefaultSearchLimit
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	offset := 0
+	if v := q.Get("offset"); v != "" {
+		var err error
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := Search(q.Get("q"), limit, offset)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, results)
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestSearchQuery(t *testing.T) {
+	tests := []struct {
+		q, want string
+	}{
+		{"coffee", `"coffee"*`},
+		{"  Café  latte ", `"Café"* "latte"*`},
+		{`payee:"x" OR NOT y*`, `"payee"* "x"* "OR"* "NOT"* "y"*`},
+		{"-- ()", ""},
+	}
+	for _, tt := range tests {
+		if got := SearchQuery(tt.q); got != tt.want {
+			t.Errorf("SearchQuery(%q) = %s, want %s", tt.q, got, tt.want)
+		}
+	}
+}
+
+// TestSearch needs SQLite with FTS5: go test -tags sqlite_fts5
+func TestSearch(t *testing.T) {
+	l := newLedger(t)
+
+	var fts5 int
+	if err := db.Get(&fts5, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`); err != nil {
+		t.Fatal(err)
+	}
+	if fts5 == 0 {
+		t.Skip("SQLite was built without FTS5, run with -tags sqlite_fts5")
+	}
+	if enabled, err := SetupSearch(); err != nil || !enabled {
+		t.Fatalf("SetupSearch() = %v, %v", enabled, err)
+	}
+
+	payee, memo := "<Café> & Co", "morning coffee"
+	cafe, err := CreateTransaction(l.account, &l.food, &payee, &memo, 4, "2026-01-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := CreateTransaction(l.account, &l.food, &payee, nil, 6, "2026-01-16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteTransaction(deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := Search("cafe cof", DefaultSearchLimit, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != cafe {
+		t.Fatalf("results = %+v, want transaction %d only", results, cafe)
+	}
+	if got, want := results[0].Highlights["payee"], "&lt;<mark>Café</mark>&gt; &amp; Co"; got != want {
+		t.Errorf("payee highlight = %s, want %s", got, want)
+	}
+	if got, want := results[0].Highlights["memo"], "morning <mark>coffee</mark>"; got != want {
+		t.Errorf("memo highlight = %s, want %s", got, want)
+	}
+
+	// Renaming the category reindexes its transactions
+	if err := UpdateCategory(l.food, "Eating out", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = Search("eating", DefaultSearchLimit, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != cafe {
+		t.Errorf("results = %+v, want transaction %d only", results, cafe)
+	}
+}
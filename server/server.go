@@ -1,9 +1,11 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"strings"
 	"time"
@@ -118,6 +120,38 @@ func (r *Repository[T]) Delete(id int64) error {
 	return err
 }
 
+// GetDeletedByID fetches a single soft-deleted row by ID
+func (r *Repository[T]) GetDeletedByID(id int64) (*T, error) {
+	var t T
+
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s = ? AND is_deleted = 1", r.tableName, r.idColumn)
+	err := r.db.Get(&t, query, id)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Restore clears the deleted flag of a soft-deleted row
+func (r *Repository[T]) Restore(id int64) error {
+	query := fmt.Sprintf("UPDATE %s SET is_deleted = 0, updated_at = CURRENT_TIMESTAMP WHERE %s = ? AND is_deleted = 1", r.tableName, r.idColumn)
+	res, err := r.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	log.Printf("[DB][OK] restored %s id=%d", r.tableName, id)
+	return nil
+}
+
 
 type ListOptions struct {
 	where   []string
@@ -155,13 +189,22 @@ func WithOffset(offset int) ListOption {
 }
 
 func (r *Repository[T]) List(opts ...ListOption) ([]T, error) {
+	return r.list(0, opts...)
+}
+
+// ListDeleted lists soft-deleted rows, i.e. the trash
+func (r *Repository[T]) ListDeleted(opts ...ListOption) ([]T, error) {
+	return r.list(1, opts...)
+}
+
+func (r *Repository[T]) list(isDeleted int, opts ...ListOption) ([]T, error) {
 	var items []T
     o := &ListOptions{}
     for _, opt := range opts {
         opt(o)
     }
     
-	query := fmt.Sprintf("SELECT * FROM %s WHERE is_deleted = 0", r.tableName)
+	query := fmt.Sprintf("SELECT * FROM %s WHERE is_deleted = %d", r.tableName, isDeleted)
 
     if len(o.where) > 0 {
         query += " AND " + strings.Join(o.where, " AND ")
@@ -186,11 +229,18 @@ func (r *Repository[T]) List(opts ...ListOption) ([]T, error) {
     return items, nil
 }
 
-func (r *Repository[T]) Reorder(id int64, oldIndex, newIndex int) error {
+// Reorder moves a row from oldIndex to newIndex, shifting the rows in between.
+// scope restricts the shift to the row's siblings, e.g. WithWhere("parent_id IS ?", parentID).
+func (r *Repository[T]) Reorder(id int64, oldIndex, newIndex int, scope ...ListOption) error {
 	if oldIndex == newIndex {
         return nil
 	}
 
+	o := &ListOptions{}
+	for _, opt := range scope {
+		opt(o)
+	}
+
 	// Determine shift direction
 	var shiftOp string
 	var args []interface{}
@@ -212,7 +262,13 @@ func (r *Repository[T]) Reorder(id int64, oldIndex, newIndex int) error {
 		WHERE is_deleted = 0 AND sort_order BETWEEN ? AND ? AND id != ?
 	`, r.tableName, shiftOp)
 
-	_, err := r.db.Exec(shiftQuery, append(args, id)...)
+	args = append(args, id)
+	if len(o.where) > 0 {
+		shiftQuery += " AND " + strings.Join(o.where, " AND ")
+		args = append(args, o.args...)
+	}
+
+	_, err := r.db.Exec(shiftQuery, args...)
 	if err != nil {
 		return err
 	}
@@ -236,16 +292,18 @@ func (r *Repository[T]) Reorder(id int64, oldIndex, newIndex int) error {
 
 // Category represents a category in the database
 type Category struct {
-	ID        int64    `json:"id"`
-	Name      string   `json:"name"`
-    ParentID  *int64   `db:"parent_id" json:"parent_id,omitempty"`
-	Amount    *float64 `json:"amount"`
-	SortOrder int64    `db:"sort_order" json:"sort_order"`
-	CreatedAt string   `db:"created_at" json:"created_at"`
-	UpdatedAt string   `db:"updated_at" json:"updated_at"`
-	IsDeleted string   `db:"is_deleted" json:"is_deleted"`
-
-	Categories []*Category `json:"categories,omitempty"`
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+    ParentID  *int64    `db:"parent_id" json:"parent_id,omitempty"`
+	Amount    *float64  `json:"amount"`
+	SortOrder int64     `db:"sort_order" json:"sort_order"`
+	IsIncome  bool      `db:"is_income" json:"is_income"`
+	CreatedAt Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt Timestamp `db:"updated_at" json:"updated_at"`
+	IsDeleted string    `db:"is_deleted" json:"is_deleted"`
+
+	Categories []*Category    `json:"categories,omitempty"`
+	Goal       *GoalProgress `db:"-" json:"goal,omitempty"`
 }
 
 // CategoryRequest represents the JSON request for creating a category
@@ -253,6 +311,7 @@ type CategoryRequest struct {
 	Name     string `json:"name"`
 	ParentID *int64 `json:"parent_id,omitempty"`
 	Amount   *float64 `json:"amount"`
+	IsIncome *bool  `json:"is_income,omitempty"`
 }
 
 type UpdateCategoryRequest struct {
@@ -260,6 +319,7 @@ type UpdateCategoryRequest struct {
 	Name     string `json:"name"`
 	Amount   *float64 `json:"amount"`
 	ParentID *int64 `json:"parent_id,omitempty"`
+	IsIncome *bool  `json:"is_income,omitempty"`
 }
 
 type ReorderRequest struct {
@@ -269,9 +329,25 @@ type ReorderRequest struct {
 
 type CreateAccountRequest struct {
 	Name     string   `json:"name"`
+	Type     string   `json:"type,omitempty"`
 	Balance   *float64 `json:"balance,omitempty"`
 }
 
+// AccountTypes lists the accepted account types, mapped to whether the type
+// is a liability. Liability balances are negative while money is owed.
+var AccountTypes = map[string]bool{
+	"":            false,
+	"cash":        false,
+	"checking":    false,
+	"savings":     false,
+	"investment":  false,
+	"asset":       false,
+	"credit_card": true,
+	"loan":        true,
+	"mortgage":    true,
+	"liability":   true,
+}
+
 type UpdateAccountRequest struct {
 	ID       int64  `json:"id"`
 	Name     string `json:"name"`
@@ -281,60 +357,138 @@ type UpdateAccountRequest struct {
 
 // Account represents an account in the database
 type Account struct {
-	ID        int64   `json:"id"`
-	Name      string  `json:"name"`
-	Type      string  `json:"type"`
-	Balance   float64 `json:"balance"`
-	CreatedAt string  `db:"created_at" json:"created_at"`
-	UpdatedAt string  `db:"updated_at" json:"updated_at"`
-	IsDeleted int     `db:"is_deleted" json:"is_deleted"`
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Balance   float64   `json:"balance"`
+	CreatedAt Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt Timestamp `db:"updated_at" json:"updated_at"`
+	IsDeleted int       `db:"is_deleted" json:"is_deleted"`
 }
 
 // Transaction represents a transaction in the database
 type TransactionWithRelations struct {
-	ID                int64    `json:"id"`
-	AccountID         int64    `db:"account_id" json:"account_id"`
-	AccountName       string   `db:"account_name" json:"account_name"`
-	CategoryID        *int64   `db:"category_id" json:"category_id,omitempty"`
-	CategoryName      *string  `db:"category_name" json:"category_name,omitempty"`
-	Payee             *string  `json:"payee,omitempty"`
-	Memo              *string  `json:"memo,omitempty"`
-	Amount            float64  `json:"amount"`
-	Date              string   `json:"date"`
-	TransferAccountID *int64   `db:"transfer_account_id" json:"transfer_account_id,omitempty"`
-	CreatedAt         string   `db:"created_at" json:"created_at"`
-	UpdatedAt         string   `db:"updated_at" json:"updated_at"`
-	IsDeleted         int      `db:"is_deleted" json:"is_deleted"`
+	ID                int64     `json:"id"`
+	AccountID         int64     `db:"account_id" json:"account_id"`
+	AccountName       string    `db:"account_name" json:"account_name"`
+	CategoryID        *int64    `db:"category_id" json:"category_id,omitempty"`
+	CategoryName      *string   `db:"category_name" json:"category_name,omitempty"`
+	Payee             *string   `json:"payee,omitempty"`
+	PayeeID           *int64    `db:"payee_id" json:"payee_id,omitempty"`
+	Memo              *string   `json:"memo,omitempty"`
+	Amount            float64   `json:"amount"`
+	Direction         string    `db:"direction" json:"direction"`
+	Date              string    `json:"date"`
+	TransferAccountID *int64    `db:"transfer_account_id" json:"transfer_account_id,omitempty"`
+	ScheduleID        *int64    `db:"schedule_id" json:"schedule_id,omitempty"`
+	Cleared           bool      `db:"cleared" json:"cleared"`
+	CreatedAt         Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt         Timestamp `db:"updated_at" json:"updated_at"`
+	IsDeleted         int       `db:"is_deleted" json:"is_deleted"`
+	Tags              []string  `db:"-" json:"tags"`
 }
 
 type Transaction struct {
-	ID                int64    `json:"id"`
-	AccountID         int64    `db:"account_id" json:"account_id"`
-	CategoryID        *int64   `db:"category_id" json:"category_id,omitempty"`
-	Payee             *string  `json:"payee,omitempty"`
-	Memo              *string  `json:"memo,omitempty"`
-	Amount            float64  `json:"amount"`
-	Date              string   `json:"date"`
-	TransferAccountID *int64   `db:"transfer_account_id" json:"transfer_account_id,omitempty"`
-	CreatedAt         string   `db:"created_at" json:"created_at"`
-	UpdatedAt         string   `db:"updated_at" json:"updated_at"`
-	IsDeleted         int      `db:"is_deleted" json:"is_deleted"`
+	ID                int64     `json:"id"`
+	AccountID         int64     `db:"account_id" json:"account_id"`
+	CategoryID        *int64    `db:"category_id" json:"category_id,omitempty"`
+	Payee             *string   `json:"payee,omitempty"`
+	PayeeID           *int64    `db:"payee_id" json:"payee_id,omitempty"`
+	Memo              *string   `json:"memo,omitempty"`
+	Amount            float64   `json:"amount"`
+	Date              string    `json:"date"`
+	TransferAccountID *int64    `db:"transfer_account_id" json:"transfer_account_id,omitempty"`
+	ScheduleID        *int64    `db:"schedule_id" json:"schedule_id,omitempty"`
+	Cleared           bool      `db:"cleared" json:"cleared"`
+	CreatedAt         Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt         Timestamp `db:"updated_at" json:"updated_at"`
+	IsDeleted         int       `db:"is_deleted" json:"is_deleted"`
 }
 
+// CreaateTransactionRequest amounts follow the ledger's sign convention:
+// positive amounts are outflows (spending) and negative amounts inflows
+// (income). Direction, when given, overrides the sign of Amount.
 type CreaateTransactionRequest struct {
 	AccountID         int64    `db:"account_id" json:"account_id"`
 	CategoryID        *int64   `db:"category_id" json:"category_id,omitempty"`
 	Payee             *string  `json:"payee,omitempty"`
 	Memo              *string  `json:"memo,omitempty"`
 	Amount            float64  `json:"amount"`
+	Direction         string   `json:"direction,omitempty"`
 	Date              string   `json:"date"`
 }
 
+const (
+	DirectionInflow  = "inflow"
+	DirectionOutflow = "outflow"
+)
+
 type MethodHandler map[string]http.HandlerFunc
 
+// HTTPError is an error that carries the HTTP status it should be reported with
+type HTTPError struct {
+	Status  int
+	Message string
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+func BadRequest(format string, args ...any) error {
+	return &HTTPError{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+func NotFound(format string, args ...any) error {
+	return &HTTPError{Status: http.StatusNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func Conflict(format string, args ...any) error {
+	return &HTTPError{Status: http.StatusConflict, Message: fmt.Sprintf(format, args...)}
+}
+
 type CreateFunc[T any] func(req T) (int64, error)
 type ValidateFunc[T any] func(req T) error
 
+// WriteJSON encodes v as the JSON response body
+func WriteJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// WriteError reports err to the client, hiding anything that isn't an HTTPError
+func WriteError(w http.ResponseWriter, err error) {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		http.Error(w, httpErr.Message, httpErr.Status)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("[DB][ERROR] %v\n", err)
+	http.Error(w, "Database error", http.StatusInternalServerError)
+}
+
+// PathID parses the {name} path segment as a row ID
+func PathID(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 64)
+	if err != nil {
+		return 0, BadRequest("Invalid %s", name)
+	}
+	return id, nil
+}
+
+// StringValue dereferences an optional string for logging
+func StringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
 // LogRequest logs HTTP requests
 func LogRequest(ip, method, path string, status int) {
 	now := time.Now().Format("2006-01-02 15:04:05")
@@ -362,8 +516,7 @@ func HandleCreate[T any](
 
 	id, err := create(*req)
 	if err != nil {
-		log.Printf("[DB][ERROR] %v\n", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		WriteError(w, err)
 		return
 	}
 
@@ -421,28 +574,41 @@ func SumCategoryAmounts(cat *Category) float64 {
 }
 
 
-// InsertCategory inserts a new category into the database
-func InsertCategory(name string, parentID *int64) (int64, error) {
+// InsertCategory inserts a new category into the database, after the
+// existing children of its parent. A nil isIncome inherits the parent's.
+// The parent must be live and leave room below it within MaxCategoryDepth.
+func InsertCategory(name string, parentID *int64, isIncome *bool) (int64, error) {
 	var id int64
 
-	if parentID != nil {
-		result, err := db.Exec("INSERT INTO categories (name, parent_id) VALUES (?, ?)", name, *parentID)
-		if err != nil {
-			return 0, err
-		}
-		id, err = result.LastInsertId()
-		if err != nil {
-			return 0, err
-		}
-	} else {
-		result, err := db.Exec("INSERT INTO categories (name) VALUES (?)", name)
-		if err != nil {
-			return 0, err
-		}
-		id, err = result.LastInsertId()
-		if err != nil {
-			return 0, err
-		}
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO categories (name, parent_id, sort_order, is_income)
+		VALUES (?, ?, (
+			SELECT COALESCE(MAX(sort_order) + 1, 0)
+			FROM categories
+			WHERE is_deleted = 0 AND parent_id IS ?
+		), COALESCE(?, (SELECT is_income FROM categories WHERE id = ?), 0))
+	`, name, parentID, parentID, isIncome, parentID)
+	if err != nil {
+		return 0, err
+	}
+	id, err = result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	// Checked once the row exists, the same way a move is
+	if err := validateCategoryParent(tx, id, parentID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
 	}
 
 	log.Printf("[DB] insert_category(name=\"%s\", parent_id=%v)\n", name, parentID)
@@ -450,9 +616,7 @@ func InsertCategory(name string, parentID *int64) (int64, error) {
 	return id, nil
 }
 
-func UpdateCategory(id int64, name string, amount *float64, parentID *int64) error {
-	catRepo := NewRepository[Category](db, "categories", "id")
-
+func UpdateCategory(id int64, name string, amount *float64, parentID *int64, isIncome *bool) error {
 	updates := map[string]interface{}{}
 
 	if name != "" {
@@ -461,40 +625,114 @@ func UpdateCategory(id int64, name string, amount *float64, parentID *int64) err
 	if amount != nil {
 		updates["amount"] = *amount
 	}
-	if parentID != nil {
-		updates["parent_id"] = *parentID
+	if isIncome != nil {
+		updates["is_income"] = *isIncome
 	}
 
-	if len(updates) == 0 {
+	// The move and the other changes are made together or not at all
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current Category
+	if err := tx.Get(&current, `SELECT * FROM categories WHERE id = ? AND is_deleted = 0`, id); err != nil {
+		return err
+	}
+
+	// Re-parenting goes through moveCategory so it is checked for cycles and
+	// depth, and lands at the end of its new siblings. A nil parentID means
+	// "no change", use the move endpoint to move a category to the root.
+	moved := parentID != nil && !sameID(current.ParentID, parentID)
+	if moved {
+		if _, err := moveCategory(tx, id, parentID, nil); err != nil {
+			return err
+		}
+	}
+
+	if len(updates) == 0 && !moved {
 		// nothing to update
 		return nil
 	}
 
-	err := catRepo.Update(id, updates)
-	if err != nil {
+	if len(updates) > 0 {
+		setClauses := []string{"updated_at = CURRENT_TIMESTAMP"}
+		args := []interface{}{}
+		for col, val := range updates {
+			setClauses = append(setClauses, col+" = ?")
+			args = append(args, val)
+		}
+		_, err = tx.Exec(`UPDATE categories SET `+strings.Join(setClauses, ", ")+` WHERE id = ?`, append(args, id)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
 		return err
 	}
 
-	log.Printf("[DB][OK] update_category(id=%d, updates=%+v)\n", id, updates)
+	log.Printf("[DB][OK] update_category(id=%d, parent_id=%v, updates=%+v)\n", id, parentID, updates)
 	return nil
 }
 
-func DeleteCategory(id int64) error {
-	catRepo := NewRepository[Category](db, "categories", "id")
+// DeleteCategory soft-deletes a category. strategy decides what happens to
+// its subcategories, transactions and remaining amount, see DeleteStrategy.
+func DeleteCategory(id int64, strategy DeleteStrategy, targetID *int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	err := catRepo.Delete(id)
+	usage, err := GetCategoryUsage(tx, id)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("[DB][OK] delete_category(id=%d)\n", id)
+	switch strategy {
+	case DeleteRefuse:
+		if usage.Subcategories > 0 || usage.Transactions > 0 || usage.Schedules > 0 || usage.Payees > 0 || usage.Rules > 0 {
+			return Conflict("category has %d subcategories, %d transactions, %d schedules, %d payee defaults and %d rules, choose the move or merge strategy",
+				usage.Subcategories, usage.Transactions, usage.Schedules, usage.Payees, usage.Rules)
+		}
+	case DeleteMove, DeleteMerge:
+		if targetID == nil {
+			return BadRequest("target_id is required for the %s strategy", strategy)
+		}
+		if err := validateMergeTarget(tx, id, *targetID); err != nil {
+			return err
+		}
+		if err := moveCategoryContents(tx, id, *targetID, strategy == DeleteMerge); err != nil {
+			return err
+		}
+	default:
+		return BadRequest("unknown delete strategy %q", strategy)
+	}
+
+	_, err = tx.Exec(`UPDATE categories SET is_deleted = 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("[DB][OK] delete_category(id=%d, strategy=%s, target_id=%v)\n", id, strategy, targetID)
 	return nil
 }
 
-func CreateAccount(name string, balance *float64) (int64, error) {
+func CreateAccount(name string, accountType string, balance *float64) (int64, error) {
 	var id int64
 
-	result, err := db.Exec("INSERT INTO accounts (name, balance, type) VALUES (?, ?, ?)", name, *balance, "")
+	opening := 0.0
+	if balance != nil {
+		opening = *balance
+	}
+
+	result, err := db.Exec("INSERT INTO accounts (name, balance, type) VALUES (?, ?, ?)", name, opening, accountType)
 	if err != nil {
 		return 0, err
 	}
@@ -503,7 +741,7 @@ func CreateAccount(name string, balance *float64) (int64, error) {
 		return 0, err
 	}
 
-	log.Printf("[DB] insert_account(name=\"%s\", balance=%v)\n", name, balance)
+	log.Printf("[DB] insert_account(name=\"%s\", type=%s, balance=%v)\n", name, accountType, opening)
 	log.Printf("[DB][OK] account inserted with id=%d\n", id)
 	return id, nil
 }
@@ -517,20 +755,65 @@ func CreateTransaction(
     Amount float64,
     Date string,      
 ) (int64, error) {
-    tx, err := db.Begin()
+    tx, err := db.Beginx()
     if err != nil {
         return 0, err
     }
     defer func() {
         tx.Rollback()
     }()
+
+	id, err := InsertTransaction(tx, AccountID, CategoryID, Payee, Memo, Amount, Date)
+	if err != nil {
+		return 0, err
+	}
+
+	// Commit transaction
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// InsertTransaction is CreateTransaction within tx, for callers that have
+// more to do before the transaction may count as posted
+func InsertTransaction(
+	tx *sqlx.Tx,
+	AccountID int64,
+	CategoryID *int64,
+	Payee *string,
+	Memo *string,
+	Amount float64,
+	Date string,
+) (int64, error) {
 	var id int64
 
+	// Dates are stored as YYYY-MM-DD, whatever form they came in
+	Date, err := ParseDate(Date)
+	if err != nil {
+		return 0, err
+	}
+
+	// 1️⃣ Link the payee, which may also supply the category
+	var payeeID *int64
+	if Payee != nil && strings.TrimSpace(*Payee) != "" {
+		p, err := UsePayee(tx, *Payee, CategoryID)
+		if err != nil {
+			return 0, err
+		}
+		payeeID = &p.ID
+		Payee = &p.Name
+		if CategoryID == nil {
+			CategoryID = p.DefaultCategoryID
+		}
+	}
+
 	result, err := 
-        tx.Exec("INSERT INTO transactions (account_id, category_id, payee, memo, amount, date) VALUES (?, ?, ?, ?, ?, ?)", 
+        tx.Exec("INSERT INTO transactions (account_id, category_id, payee, payee_id, memo, amount, date) VALUES (?, ?, ?, ?, ?, ?, ?)", 
             AccountID, 
             CategoryID,
             Payee,
+            payeeID,
             Memo,
             Amount,
             Date,
@@ -553,25 +836,21 @@ func CreateTransaction(
 		return 0, err
 	}
 
+    // Uncategorized transactions (nil CategoryID) match no category here
     _, err = tx.Exec(
         `UPDATE categories SET amount = amount - ? WHERE id = ?`,
         Amount,
-        *CategoryID,
+        CategoryID,
     )
     if err != nil {
         return 0, err
     }
 
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
-		return 0, err
-	}
-
 	log.Printf("[DB] insert_transaction(account_id=%d, category_id=%v, payee=%s, memo=%s, amount=%v, date=%s)\n", 
         AccountID, 
         CategoryID,
-        Payee,
-        Memo,
+        StringValue(Payee),
+        StringValue(Memo),
         Amount,
         Date,
     )
@@ -579,6 +858,90 @@ func CreateTransaction(
 	return id, nil
 }
 
+// SignedAmount applies the ledger's sign convention to amount. Without an
+// explicit direction, transactions in income categories are inflows and
+// anything else keeps the sign it was given.
+func SignedAmount(q sqlx.Queryer, categoryID *int64, direction string, amount float64) (float64, error) {
+	if direction == "" && categoryID != nil {
+		var isIncome bool
+		err := sqlx.Get(q, &isIncome, `SELECT is_income FROM categories WHERE id = ? AND is_deleted = 0`, *categoryID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+		if isIncome {
+			direction = DirectionInflow
+		}
+	}
+
+	switch direction {
+	case "":
+		return amount, nil
+	case DirectionInflow:
+		return -math.Abs(amount), nil
+	case DirectionOutflow:
+		return math.Abs(amount), nil
+	default:
+		return 0, BadRequest("direction must be inflow or outflow")
+	}
+}
+
+// applyTransactionEffect adds sign * amount of tx's effect to its account
+// balance and category amount. CreateTransaction applies it with sign 1,
+// deleting a transaction reverts it with sign -1.
+func applyTransactionEffect(tx *sqlx.Tx, t *Transaction, sign float64) error {
+	_, err := tx.Exec(
+		`UPDATE accounts SET balance = balance - ? WHERE id = ?`,
+		sign*t.Amount,
+		t.AccountID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if t.CategoryID == nil {
+		return nil
+	}
+
+	_, err = tx.Exec(
+		`UPDATE categories SET amount = amount - ? WHERE id = ?`,
+		sign*t.Amount,
+		*t.CategoryID,
+	)
+	return err
+}
+
+// DeleteTransaction soft-deletes a transaction and reverts its balance and
+// category effects
+func DeleteTransaction(id int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var t Transaction
+	err = tx.Get(&t, `SELECT * FROM transactions WHERE id = ? AND is_deleted = 0`, id)
+	if err != nil {
+		return err
+	}
+
+	if err := applyTransactionEffect(tx, &t, -1); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE transactions SET is_deleted = 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("[DB][OK] delete_transaction(id=%d)\n", id)
+	return nil
+}
+
 // HandleCreateCategory handles POST /category requests
 func HandleCreateCategory(w http.ResponseWriter, r *http.Request) {
 	var req CategoryRequest
@@ -594,7 +957,7 @@ func HandleCreateCategory(w http.ResponseWriter, r *http.Request) {
 			return nil
 		},
 		func(r CategoryRequest) (int64, error) {
-			return InsertCategory(r.Name, r.ParentID)
+			return InsertCategory(r.Name, r.ParentID, r.IsIncome)
 		},
 	)
 }
@@ -618,9 +981,8 @@ func HandleUpdateCategoryByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := UpdateCategory(id, req.Name, req.Amount, req.ParentID); err != nil {
-		log.Printf("[DB][ERROR] %v\n", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	if err := UpdateCategory(id, req.Name, req.Amount, req.ParentID, req.IsIncome); err != nil {
+		WriteError(w, err)
 		return
 	}
 
@@ -630,22 +992,35 @@ func HandleUpdateCategoryByID(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// HandleDeleteCategory handles DELETE /categories/{id}?strategy=...&target_id=...
 func HandleDeleteCategory(w http.ResponseWriter, r *http.Request) {
-	idStr := r.PathValue("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	id, err := PathID(r, "id")
 	if err != nil {
-		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		WriteError(w, err)
 		return
 	}
 
-	if err := DeleteCategory(id); err != nil {
-		log.Printf("[DB][ERROR] %v\n", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	strategy := DeleteStrategy(r.URL.Query().Get("strategy"))
+	if strategy == "" {
+		strategy = DeleteRefuse
+	}
+
+	var targetID *int64
+	if v := r.URL.Query().Get("target_id"); v != "" {
+		target, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid target_id", http.StatusBadRequest)
+			return
+		}
+		targetID = &target
+	}
+
+	if err := DeleteCategory(id, strategy, targetID); err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	WriteJSON(w, map[string]string{
 		"status": "OK",
 	})
 }
@@ -675,6 +1050,12 @@ func HandleGetCategories(w http.ResponseWriter, r *http.Request) {
         SumCategoryAmounts(root)
     }
 
+	if err := AttachGoals(categories); err != nil {
+		log.Printf("[DB][ERROR] %v\n", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
     log.Printf("[DB][OK] get_categories cat=%v\n", tree)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(tree)
@@ -695,7 +1076,14 @@ func ReorderCategoryHandler(w http.ResponseWriter, r *http.Request) {
     }
 
 	catRepo := NewRepository[Category](db, "categories", "id")
-    if err := catRepo.Reorder(id, req.OldIndex, req.NewIndex); err != nil {
+	cat, err := catRepo.GetByID(id)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	// Indexes are positions among the category's siblings
+    if err := catRepo.Reorder(id, req.OldIndex, req.NewIndex, WithWhere("parent_id IS ?", cat.ParentID)); err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
@@ -717,10 +1105,13 @@ func HandleCreateAccount(w http.ResponseWriter, r *http.Request) {
 			if r.Name == "" {
 				return errors.New("name is required")
 			}
+			if _, ok := AccountTypes[r.Type]; !ok {
+				return fmt.Errorf("unknown account type %q", r.Type)
+			}
 			return nil
 		},
 		func(r CreateAccountRequest) (int64, error) {
-			return CreateAccount(r.Name, r.Balance)
+			return CreateAccount(r.Name, r.Type, r.Balance)
 		},
 	)
 }
@@ -750,13 +1141,26 @@ func HandleCreateTransaction(w http.ResponseWriter, r *http.Request) {
 			if r.AccountID == 0 {
 				return errors.New("account id is required")
 			}
-
-			if r.CategoryID == nil {
-				return errors.New("category id is required")
+			if _, err := ParseDate(r.Date); err != nil {
+				return err
 			}
+
 			return nil
 		},
 		func(r CreaateTransactionRequest) (int64, error) {
+			rules, err := LoadRules()
+			if err != nil {
+				return 0, err
+			}
+
+			r, _, err = PrepareTransaction(db, rules, r)
+			if err != nil {
+				return 0, err
+			}
+			if r.CategoryID == nil {
+				return 0, BadRequest("category id is required, no rule or payee default supplied one")
+			}
+
 			return CreateTransaction(
                 r.AccountID,
                 r.CategoryID,
@@ -770,20 +1174,15 @@ func HandleCreateTransaction(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleGetTransaction(w http.ResponseWriter, r *http.Request) {
-	var txs []TransactionWithRelations
-
-	err := db.Select(&txs, `
-		SELECT
-			t.*,
-			a.name AS account_name,
-			c.name AS category_name
-		FROM transactions t
-		JOIN accounts a ON a.id = t.account_id
-		LEFT JOIN categories c ON c.id = t.category_id
-	`)
+	filter, err := ParseTransactionFilter(r.URL.Query())
 	if err != nil {
-		log.Printf("[DB][ERROR] %v\n", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		WriteError(w, err)
+		return
+	}
+
+	txs, err := ListTransactions(filter)
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
 
@@ -791,6 +1190,23 @@ func HandleGetTransaction(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(txs)
 }
 
+func HandleDeleteTransaction(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	if err := DeleteTransaction(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
+
 // LoggingMiddleware wraps handlers to log requests
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -878,6 +1294,16 @@ func main() {
 		log.Fatal(err)
 	}
 
+	searchEnabled, err := SetupSearch()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Dates and periods fall back to the defaults until settings are saved
+	if err := LoadSettings(); err != nil {
+		log.Printf("[DB][ERROR] load_settings: %v\n", err)
+	}
+
 	mux := http.NewServeMux()
 
 	// Set up HTTP routes
@@ -892,16 +1318,37 @@ func main() {
 		http.MethodDelete: HandleDeleteCategory,
 	}))
 
-	mux.Handle("/categories/reorder/{id}", Methods(MethodHandler{
+	mux.Handle("/categories/reorder", Methods(MethodHandler{
+		http.MethodPut: HandleBulkReorderCategories,
+	}))
+
+	mux.Handle("/categories/reorder/repair", Methods(MethodHandler{
+		http.MethodPost: HandleRepairCategoryOrder,
+	}))
+
+	mux.Handle("/categories/{id}/reorder", Methods(MethodHandler{
 		http.MethodPut: ReorderCategoryHandler,
 	}))
 
+	mux.Handle("/categories/{id}/move", Methods(MethodHandler{
+		http.MethodPut: HandleMoveCategory,
+	}))
+
+	mux.Handle("/categories/{id}/merge", Methods(MethodHandler{
+		http.MethodGet: HandlePreviewMergeCategory,
+		http.MethodPost: HandleMergeCategory,
+	}))
+
 	// Set up HTTP routes
 	mux.Handle("/accounts", Methods(MethodHandler{
 		http.MethodPost: HandleCreateAccount,
 		http.MethodGet: HandleGetAccounts,
 	}))
 
+	mux.Handle("/accounts/{id}/register", Methods(MethodHandler{
+		http.MethodGet: HandleGetAccountRegister,
+	}))
+
 	//mux.Handle("/accounts/{id}", Methods(MethodHandler{
 	//	http.MethodPut: HandleUpdateAccount,
 	//}))
@@ -911,11 +1358,210 @@ func main() {
 		http.MethodGet: HandleGetTransaction,
 	}))
 
+	mux.Handle("/transactions/{id}", Methods(MethodHandler{
+		http.MethodDelete: HandleDeleteTransaction,
+	}))
+
+	mux.Handle("/reports/spending", Methods(MethodHandler{
+		http.MethodGet: HandleSpendingReport,
+	}))
+
+	mux.Handle("/reports/cash-flow", Methods(MethodHandler{
+		http.MethodGet: HandleCashFlowReport,
+	}))
+
+	mux.Handle("/reports/net-worth", Methods(MethodHandler{
+		http.MethodGet: HandleNetWorthReport,
+	}))
+
+	mux.Handle("/reports/budget", Methods(MethodHandler{
+		http.MethodGet: HandleBudgetReport,
+	}))
+
+	mux.Handle("/budgets", Methods(MethodHandler{
+		http.MethodGet: HandleGetBudgets,
+		http.MethodPut: HandleSetBudget,
+	}))
+
+	mux.Handle("/goals", Methods(MethodHandler{
+		http.MethodGet: HandleGetGoals,
+		http.MethodPut: HandleSetGoal,
+	}))
+
+	mux.Handle("/goals/{id}", Methods(MethodHandler{
+		http.MethodDelete: HandleDeleteGoal,
+	}))
+
+	mux.Handle("/schedules", Methods(MethodHandler{
+		http.MethodGet: HandleGetSchedules,
+		http.MethodPost: HandleCreateSchedule,
+	}))
+
+	mux.Handle("/schedules/{id}", Methods(MethodHandler{
+		http.MethodDelete: HandleDeleteSchedule,
+	}))
+
+	mux.Handle("/schedules/upcoming", Methods(MethodHandler{
+		http.MethodGet: HandleUpcomingSchedules,
+	}))
+
+	mux.Handle("/schedules/run", Methods(MethodHandler{
+		http.MethodPost: HandleRunSchedules,
+	}))
+
+	mux.Handle("/schedules/{id}/skip", Methods(MethodHandler{
+		http.MethodPost: HandleSkipOccurrence,
+	}))
+
+	mux.Handle("/schedules/{id}/postpone", Methods(MethodHandler{
+		http.MethodPost: HandlePostponeOccurrence,
+	}))
+
+	mux.Handle("/forecast", Methods(MethodHandler{
+		http.MethodGet: HandleForecast,
+	}))
+
+	mux.Handle("/bills/upcoming", Methods(MethodHandler{
+		http.MethodGet: HandleUpcomingBills,
+	}))
+
+	mux.Handle("/transactions/import", Methods(MethodHandler{
+		http.MethodPost: HandleImportTransactions,
+	}))
+
+	mux.Handle("/transactions/bulk", Methods(MethodHandler{
+		http.MethodPost: HandleBulkTransactions,
+	}))
+
+	mux.Handle("/transactions/duplicates", Methods(MethodHandler{
+		http.MethodGet: HandleFindDuplicates,
+	}))
+
+	mux.Handle("/transactions/{id}/merge", Methods(MethodHandler{
+		http.MethodPost: HandleMergeTransaction,
+	}))
+
+	mux.Handle("/transactions/suggest", Methods(MethodHandler{
+		http.MethodGet: HandleSuggestCategory,
+	}))
+
+	mux.Handle("/transactions/suggest/train", Methods(MethodHandler{
+		http.MethodPost: HandleTrainCategoryModel,
+	}))
+
+	mux.Handle("/transactions/{id}/attachments", Methods(MethodHandler{
+		http.MethodGet:  HandleGetAttachments,
+		http.MethodPost: HandleUploadAttachment,
+	}))
+
+	mux.Handle("/attachments/{id}", Methods(MethodHandler{
+		http.MethodGet:    HandleDownloadAttachment,
+		http.MethodDelete: HandleDeleteAttachment,
+	}))
+
+	mux.Handle("/export", Methods(MethodHandler{
+		http.MethodGet: HandleExport,
+	}))
+
+	mux.Handle("/tags", Methods(MethodHandler{
+		http.MethodGet:  HandleGetTags,
+		http.MethodPost: HandleCreateTag,
+	}))
+
+	mux.Handle("/tags/{id}", Methods(MethodHandler{
+		http.MethodDelete: HandleDeleteTag,
+	}))
+
+	mux.Handle("/tags/bulk", Methods(MethodHandler{
+		http.MethodPost: HandleBulkTag,
+	}))
+
+	mux.Handle("/rules", Methods(MethodHandler{
+		http.MethodGet:  HandleGetRules,
+		http.MethodPost: HandleCreateRule,
+	}))
+
+	mux.Handle("/rules/{id}", Methods(MethodHandler{
+		http.MethodPut:    HandleUpdateRule,
+		http.MethodDelete: HandleDeleteRule,
+	}))
+
+	mux.Handle("/rules/run", Methods(MethodHandler{
+		http.MethodPost: HandleRunRules,
+	}))
+
+	mux.Handle("/filters", Methods(MethodHandler{
+		http.MethodGet:  HandleGetSavedFilters,
+		http.MethodPost: HandleCreateSavedFilter,
+	}))
+
+	mux.Handle("/filters/{id}", Methods(MethodHandler{
+		http.MethodPut:    HandleUpdateSavedFilter,
+		http.MethodDelete: HandleDeleteSavedFilter,
+	}))
+
+	mux.Handle("/filters/{id}/transactions", Methods(MethodHandler{
+		http.MethodGet: HandleRunSavedFilter,
+	}))
+
+	if searchEnabled {
+		mux.Handle("/search", Methods(MethodHandler{
+			http.MethodGet: HandleSearch,
+		}))
+	}
+
+	mux.Handle("/payees", Methods(MethodHandler{
+		http.MethodGet: HandleSearchPayees,
+	}))
+
+	mux.Handle("/payees/{id}", Methods(MethodHandler{
+		http.MethodPut: HandleUpdatePayee,
+	}))
+
+	mux.Handle("/payees/{id}/aliases", Methods(MethodHandler{
+		http.MethodPost: HandleAddPayeeAlias,
+	}))
+
+	mux.Handle("/payees/rebuild", Methods(MethodHandler{
+		http.MethodPost: HandleRebuildPayees,
+	}))
+
+	mux.Handle("/periods", Methods(MethodHandler{
+		http.MethodGet: HandleGetPeriods,
+		http.MethodPost: HandleCreatePeriod,
+	}))
+
+	mux.Handle("/periods/{id}", Methods(MethodHandler{
+		http.MethodPut: HandleUpdatePeriod,
+		http.MethodDelete: HandleDeletePeriod,
+	}))
+
+	mux.Handle("/settings", Methods(MethodHandler{
+		http.MethodGet: HandleGetSettings,
+		http.MethodPut: HandleUpdateSettings,
+	}))
+
+	mux.Handle("/settings/normalize-dates", Methods(MethodHandler{
+		http.MethodPost: HandleNormalizeDates,
+	}))
+
+	mux.Handle("/trash/{entity}", Methods(MethodHandler{
+		http.MethodGet: HandleListTrash,
+		http.MethodDelete: HandlePurgeTrash,
+	}))
+
+	mux.Handle("/trash/{entity}/{id}/restore", Methods(MethodHandler{
+		http.MethodPost: HandleRestoreFromTrash,
+	}))
+
 	// Handle 404 for all other routes
 	mux.Handle("/", http.NotFoundHandler())
 
 	handler := WithMiddleware(mux)
 
+	// Post recurring transactions as they come due
+	go RunScheduler(SchedulerInterval)
+
 	// Start server
 	addr := fmt.Sprintf(":%d", PORT)
 	log.Printf("Server running on http://localhost:%d\n", PORT)
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// openTestDB points db at a fresh database in a temporary directory with
+// every migration applied. The full-text index is left out when this build
+// of SQLite has no FTS5.
+func openTestDB(t *testing.T) {
+	t.Helper()
+
+	testDB, err := sqlx.Connect("sqlite3", filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fts5 int
+	if err := testDB.Get(&fts5, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join("db", "migrations", "*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		if fts5 == 0 && strings.HasSuffix(f, "_add_transactions_fts.sql") {
+			continue
+		}
+		migration, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := testDB.Exec(string(migration)); err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+	}
+
+	previous := db
+	db = testDB
+	t.Cleanup(func() {
+		db = previous
+		testDB.Close()
+	})
+}
+
+// mustExec runs a statement of the test's setup and returns the ID it inserted
+func mustExec(t *testing.T, query string, args ...any) int64 {
+	t.Helper()
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// balances are what the tests check after each change: every account's
+// balance and every category's amount
+type balances struct {
+	Accounts   map[int64]float64
+	Categories map[int64]float64
+}
+
+func loadBalances(t *testing.T) balances {
+	t.Helper()
+
+	var rows []struct {
+		ID     int64   `db:"id"`
+		Amount float64 `db:"amount"`
+	}
+	b := balances{Accounts: map[int64]float64{}, Categories: map[int64]float64{}}
+
+	if err := db.Select(&rows, `SELECT id, balance AS amount FROM accounts`); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		b.Accounts[r.ID] = r.Amount
+	}
+
+	rows = nil
+	if err := db.Select(&rows, `SELECT id, COALESCE(amount, 0) AS amount FROM categories`); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		b.Categories[r.ID] = r.Amount
+	}
+	return b
+}
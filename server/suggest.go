@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSuggestionLimit is how many categories GET /transactions/suggest
+// ranks when the request doesn't say
+const DefaultSuggestionLimit = 3
+
+// CategoryModel is a multinomial naive Bayes classifier from transaction
+// features to categories, trained on the categorized transactions
+type CategoryModel struct {
+	TrainedAt string
+	Samples   int
+
+	priors   map[int64]int
+	counts   map[int64]map[string]int
+	totals   map[int64]int
+	features map[string]bool
+}
+
+// CategorySuggestion is one ranked category; the confidences of all
+// categories add up to 1
+type CategorySuggestion struct {
+	CategoryID int64   `json:"category_id"`
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"`
+}
+
+var (
+	categoryModelMu sync.RWMutex
+	categoryModel   *CategoryModel
+)
+
+// transactionFeatures turns a transaction into the tokens the model counts:
+// the normalized payee and its words, memo words and the order of magnitude
+// and direction of the amount
+func transactionFeatures(payee, memo string, amount float64) []string {
+	var features []string
+
+	if p := NormalizePayee(payee); p != "" {
+		features = append(features, "payee:"+p)
+		for _, w := range strings.Fields(p) {
+			features = append(features, "payee_word:"+w)
+		}
+	}
+
+	for _, w := range strings.Fields(NormalizePayee(memo)) {
+		if len(w) > 1 {
+			features = append(features, "memo:"+w)
+		}
+	}
+
+	direction := "out"
+	if amount < 0 {
+		direction = "in"
+	}
+	magnitude := int(math.Floor(math.Log2(math.Abs(amount) + 1)))
+	features = append(features,
+		"direction:"+direction,
+		fmt.Sprintf("amount:%s:%d", direction, magnitude),
+	)
+	return features
+}
+
+// TrainCategoryModel rebuilds the model from every live, categorized,
+// non-transfer transaction and makes it the one suggestions use
+func TrainCategoryModel() (*CategoryModel, error) {
+	var rows []struct {
+		CategoryID int64   `db:"category_id"`
+		Payee      *string `db:"payee"`
+		Memo       *string `db:"memo"`
+		Amount     float64 `db:"amount"`
+	}
+	err := db.Select(&rows, `
+		SELECT t.category_id, t.payee, t.memo, t.amount
+		FROM transactions t
+		JOIN categories c ON c.id = t.category_id AND c.is_deleted = 0
+		WHERE t.is_deleted = 0 AND t.transfer_account_id IS NULL
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &CategoryModel{
+		TrainedAt: time.Now().UTC().Format(time.RFC3339),
+		Samples:   len(rows),
+		priors:    map[int64]int{},
+		counts:    map[int64]map[string]int{},
+		totals:    map[int64]int{},
+		features:  map[string]bool{},
+	}
+	for _, r := range rows {
+		m.priors[r.CategoryID]++
+		if m.counts[r.CategoryID] == nil {
+			m.counts[r.CategoryID] = map[string]int{}
+		}
+		for _, f := range transactionFeatures(StringValue(r.Payee), StringValue(r.Memo), r.Amount) {
+			m.counts[r.CategoryID][f]++
+			m.totals[r.CategoryID]++
+			m.features[f] = true
+		}
+	}
+
+	categoryModelMu.Lock()
+	categoryModel = m
+	categoryModelMu.Unlock()
+
+	log.Printf("[DB][OK] train_category_model() samples=%d categories=%d features=%d\n", m.Samples, len(m.priors), len(m.features))
+	return m, nil
+}
+
+// CurrentCategoryModel is the last trained model, training one first if
+// there is none yet
+func CurrentCategoryModel() (*CategoryModel, error) {
+	categoryModelMu.RLock()
+	m := categoryModel
+	categoryModelMu.RUnlock()
+
+	if m != nil {
+		return m, nil
+	}
+	return TrainCategoryModel()
+}
+
+// liveCategoryNames maps the ID of every live category to its name
+func liveCategoryNames() (map[int64]string, error) {
+	var rows []struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+	if err := db.Select(&rows, `SELECT id, name FROM categories WHERE is_deleted = 0`); err != nil {
+		return nil, err
+	}
+
+	names := make(map[int64]string, len(rows))
+	for _, r := range rows {
+		names[r.ID] = r.Name
+	}
+	return names, nil
+}
+
+// Suggest ranks the categories in live, which maps the IDs of the
+// categories still in use to their current names, by their posterior
+// probability for the transaction, with add-one smoothing. Categories
+// deleted since the model was trained are left out. Features the model
+// never saw are ignored rather than smoothed so they don't favour small
+// categories.
+func (m *CategoryModel) Suggest(payee, memo string, amount float64, live map[int64]string, limit int) []CategorySuggestion {
+	suggestions := []CategorySuggestion{}
+	if m.Samples == 0 {
+		return suggestions
+	}
+
+	var known []string
+	for _, f := range transactionFeatures(payee, memo, amount) {
+		if m.features[f] {
+			known = append(known, f)
+		}
+	}
+
+	vocabulary := float64(len(m.features))
+	scores := map[int64]float64{}
+	best := math.Inf(-1)
+	for id, n := range m.priors {
+		if _, ok := live[id]; !ok {
+			continue
+		}
+		score := math.Log(float64(n) / float64(m.Samples))
+		for _, f := range known {
+			score += math.Log((float64(m.counts[id][f]) + 1) / (float64(m.totals[id]) + vocabulary))
+		}
+		scores[id] = score
+		best = math.Max(best, score)
+	}
+
+	// Normalize in log space so the exponentials don't underflow
+	var sum float64
+	for id, score := range scores {
+		scores[id] = math.Exp(score - best)
+		sum += scores[id]
+	}
+	for id, p := range scores {
+		suggestions = append(suggestions, CategorySuggestion{
+			CategoryID: id,
+			Name:       live[id],
+			Confidence: p / sum,
+		})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Confidence != suggestions[j].Confidence {
+			return suggestions[i].Confidence > suggestions[j].Confidence
+		}
+		return suggestions[i].CategoryID < suggestions[j].CategoryID
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// HandleSuggestCategory handles GET /transactions/suggest?payee=...&memo=...&amount=...&direction=...&limit=N.
+// Like on POST /transactions, direction inflow or outflow sets the sign of
+// amount.
+func HandleSuggestCategory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var amount float64
+	if v := q.Get("amount"); v != "" {
+		var err error
+		if amount, err = strconv.ParseFloat(v, 64); err != nil {
+			http.Error(w, "Invalid amount", http.StatusBadRequest)
+			return
+		}
+	}
+
+	amount, err := SignedAmount(db, nil, q.Get("direction"), amount)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	limit := DefaultSuggestionLimit
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	m, err := CurrentCategoryModel()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	live, err := liveCategoryNames()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"trained_at":  m.TrainedAt,
+		"samples":     m.Samples,
+		"suggestions": m.Suggest(q.Get("payee"), q.Get("memo"), amount, live, limit),
+	})
+}
+
+// HandleTrainCategoryModel handles POST /transactions/suggest/train
+func HandleTrainCategoryModel(w http.ResponseWriter, r *http.Request) {
+	m, err := TrainCategoryModel()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"status":     "OK",
+		"trained_at": m.TrainedAt,
+		"samples":    m.Samples,
+		"categories": len(m.priors),
+	})
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Tag is a label transactions can carry any number of
+type Tag struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt Timestamp `db:"updated_at" json:"updated_at"`
+	IsDeleted int       `db:"is_deleted" json:"is_deleted"`
+}
+
+// TagUsage is a tag with the live transactions carrying it
+type TagUsage struct {
+	Tag
+	Transactions int     `db:"transactions" json:"transactions"`
+	Total        float64 `db:"total" json:"total"`
+}
+
+type CreateTagRequest struct {
+	Name string `json:"name"`
+}
+
+// BulkTagRequest adds and removes tags on many transactions at once. Tags
+// added by name are created when they don't exist yet.
+type BulkTagRequest struct {
+	TransactionIDs []int64  `json:"transaction_ids"`
+	Add            []string `json:"add,omitempty"`
+	Remove         []string `json:"remove,omitempty"`
+}
+
+// NormalizeTag lower-cases a tag name and joins its words with dashes, so
+// "Vacation 2026" and "vacation-2026" are the same tag
+func NormalizeTag(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), "-")
+}
+
+// EnsureTag returns the ID of the tag called name, creating it as needed. A
+// deleted tag comes back on no transactions, as DeleteTag untagged them all.
+func EnsureTag(q sqlx.Queryer, name string) (int64, error) {
+	normalized := NormalizeTag(name)
+	if normalized == "" {
+		return 0, BadRequest("tag name is required")
+	}
+
+	var id int64
+	err := sqlx.Get(q, &id, `
+		INSERT INTO tags (name) VALUES (?)
+		ON CONFLICT (name) DO UPDATE
+		SET is_deleted = 0, updated_at = CASE WHEN is_deleted = 1 THEN CURRENT_TIMESTAMP ELSE updated_at END
+		RETURNING id
+	`, normalized)
+	return id, err
+}
+
+// ListTags lists the live tags with how many live transactions carry them
+// and their total amount
+func ListTags() ([]TagUsage, error) {
+	tags := []TagUsage{}
+	err := db.Select(&tags, `
+		SELECT g.*, COUNT(t.id) AS transactions, COALESCE(SUM(t.amount), 0) AS total
+		FROM tags g
+		LEFT JOIN transaction_tags tt ON tt.tag_id = g.id
+		LEFT JOIN transactions t ON t.id = tt.transaction_id AND t.is_deleted = 0
+		WHERE g.is_deleted = 0
+		GROUP BY g.id
+		ORDER BY g.name
+	`)
+	return tags, err
+}
+
+// DeleteTag moves a tag to the trash and takes it off every transaction, so
+// tagging anything with its name again starts it over from scratch
+func DeleteTag(id int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE tags SET is_deleted = 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND is_deleted = 0`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return NotFound("tag %d not found", id)
+	}
+
+	res, err = tx.Exec(`DELETE FROM transaction_tags WHERE tag_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	untagged, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("[DB][OK] delete_tag(id=%d) untagged=%d\n", id, untagged)
+	return nil
+}
+
+// AddTags tags the live transactions among ids with every name
+func AddTags(tx *sqlx.Tx, ids []int64, names []string) (int64, error) {
+	var added int64
+	for _, name := range names {
+		tagID, err := EnsureTag(tx, name)
+		if err != nil {
+			return 0, err
+		}
+
+		query, args, err := sqlx.In(`
+			INSERT OR IGNORE INTO transaction_tags (transaction_id, tag_id)
+			SELECT id, ? FROM transactions WHERE is_deleted = 0 AND id IN (?)
+		`, tagID, ids)
+		if err != nil {
+			return 0, err
+		}
+
+		res, err := tx.Exec(query, args...)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		added += n
+	}
+	return added, nil
+}
+
+// RemoveTags takes every named tag off the transactions among ids
+func RemoveTags(tx *sqlx.Tx, ids []int64, names []string) (int64, error) {
+	normalized := make([]string, len(names))
+	for i, name := range names {
+		normalized[i] = NormalizeTag(name)
+	}
+
+	query, args, err := sqlx.In(`
+		DELETE FROM transaction_tags
+		WHERE transaction_id IN (?)
+		  AND tag_id IN (SELECT id FROM tags WHERE name IN (?))
+	`, ids, normalized)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// BulkTag applies a BulkTagRequest in one transaction. Removals run after
+// additions, so a tag in both lists ends up removed.
+func BulkTag(req BulkTagRequest) (added, removed int64, err error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	if len(req.Add) > 0 {
+		if added, err = AddTags(tx, req.TransactionIDs, req.Add); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(req.Remove) > 0 {
+		if removed, err = RemoveTags(tx, req.TransactionIDs, req.Remove); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	log.Printf("[DB][OK] bulk_tag(transactions=%d, add=%v, remove=%v) added=%d removed=%d\n",
+		len(req.TransactionIDs), req.Add, req.Remove, added, removed)
+	return added, removed, nil
+}
+
+// LoadTransactionTags maps each of the transactions to its tag names
+func LoadTransactionTags(ids []int64) (map[int64][]string, error) {
+	tags := map[int64][]string{}
+	if len(ids) == 0 {
+		return tags, nil
+	}
+
+	query, args, err := sqlx.In(`
+		SELECT tt.transaction_id, g.name
+		FROM transaction_tags tt
+		JOIN tags g ON g.id = tt.tag_id
+		WHERE g.is_deleted = 0 AND tt.transaction_id IN (?)
+		ORDER BY g.name
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		TransactionID int64  `db:"transaction_id"`
+		Name          string `db:"name"`
+	}
+	if err := db.Select(&rows, query, args...); err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		tags[r.TransactionID] = append(tags[r.TransactionID], r.Name)
+	}
+	return tags, nil
+}
+
+// HandleGetTags handles GET /tags
+func HandleGetTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := ListTags()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, tags)
+}
+
+// HandleCreateTag handles POST /tags
+func HandleCreateTag(w http.ResponseWriter, r *http.Request) {
+	var req CreateTagRequest
+
+	HandleCreate(
+		w,
+		r,
+		&req,
+		nil,
+		func(r CreateTagRequest) (int64, error) {
+			id, err := EnsureTag(db, r.Name)
+			if err != nil {
+				return 0, err
+			}
+
+			log.Printf("[DB][OK] create_tag(name=%s) id=%d\n", NormalizeTag(r.Name), id)
+			return id, nil
+		},
+	)
+}
+
+// HandleDeleteTag handles DELETE /tags/{id}
+func HandleDeleteTag(w http.ResponseWriter, r *http.Request) {
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	if err := DeleteTag(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
+
+// HandleBulkTag handles POST /tags/bulk
+func HandleBulkTag(w http.ResponseWriter, r *http.Request) {
+	var req BulkTagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.TransactionIDs) == 0 {
+		WriteError(w, BadRequest("transaction_ids is required"))
+		return
+	}
+	if len(req.Add) == 0 && len(req.Remove) == 0 {
+		WriteError(w, BadRequest("nothing to add or remove"))
+		return
+	}
+
+	added, removed, err := BulkTag(req)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"status":  "OK",
+		"added":   added,
+		"removed": removed,
+	})
+}
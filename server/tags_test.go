@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestDeleteTagUntagsTransactions(t *testing.T) {
+	l := newLedger(t)
+
+	id, err := CreateTransaction(l.account, &l.food, nil, nil, 30, "2026-01-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := BulkTag(BulkTagRequest{TransactionIDs: []int64{id}, Add: []string{"Vacation"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tagID, err := EnsureTag(db, "vacation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteTag(tagID); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteTag(tagID); err == nil {
+		t.Error("deleted a tag twice")
+	}
+
+	// Creating the tag again must not put it back on the transaction
+	if _, err := EnsureTag(db, "vacation"); err != nil {
+		t.Fatal(err)
+	}
+	tags, err := LoadTransactionTags([]int64{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags[id]) != 0 {
+		t.Errorf("tags = %v, want none", tags[id])
+	}
+
+	usage, err := ListTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage[0].Transactions != 0 {
+		t.Errorf("tags = %+v, want vacation on no transactions", usage)
+	}
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	TagModeAny = "any"
+	TagModeAll = "all"
+)
+
+// TransactionFilter narrows GET /transactions. Tags keeps transactions
+// carrying any of the tags, or all of them with TagMode "all". Range is a
+// period selector (see ResolvePeriod) resolved when the filter runs, so a
+// saved filter for "last_month" follows the calendar; it can't be combined
+// with From/To.
+// Amount bounds compare against absolute amounts. IDs, never read from a
+// request, keeps only the given transactions.
+type TransactionFilter struct {
+	IDs        []int64  `json:"-"`
+	AccountID  *int64   `json:"account_id,omitempty"`
+	CategoryID *int64   `json:"category_id,omitempty"`
+	Payee      string   `json:"payee,omitempty"`
+	From       string   `json:"from,omitempty"`
+	To         string   `json:"to,omitempty"`
+	Range      string   `json:"range,omitempty"`
+	Cleared    *bool    `json:"cleared,omitempty"`
+	Direction  string   `json:"direction,omitempty"`
+	MinAmount  *float64 `json:"min_amount,omitempty"`
+	MaxAmount  *float64 `json:"max_amount,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	TagMode    string   `json:"tag_mode,omitempty"`
+}
+
+// ParseTransactionFilter reads a filter from the query parameters
+// account_id, category_id (subcategories included), payee, from, to, range,
+// cleared, direction, min_amount, max_amount, tag (repeatable or comma
+// separated) and tag_mode
+func ParseTransactionFilter(q url.Values) (TransactionFilter, error) {
+	var f TransactionFilter
+
+	parseID := func(name string) (*int64, error) {
+		v := q.Get(name)
+		if v == "" {
+			return nil, nil
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, BadRequest("invalid %s", name)
+		}
+		return &id, nil
+	}
+	parseAmount := func(name string) (*float64, error) {
+		v := q.Get(name)
+		if v == "" {
+			return nil, nil
+		}
+		amount, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, BadRequest("invalid %s", name)
+		}
+		return &amount, nil
+	}
+
+	var err error
+	if f.AccountID, err = parseID("account_id"); err != nil {
+		return f, err
+	}
+	if f.CategoryID, err = parseID("category_id"); err != nil {
+		return f, err
+	}
+	if f.MinAmount, err = parseAmount("min_amount"); err != nil {
+		return f, err
+	}
+	if f.MaxAmount, err = parseAmount("max_amount"); err != nil {
+		return f, err
+	}
+
+	f.Payee = strings.TrimSpace(q.Get("payee"))
+
+	f.From, f.To, f.Range = q.Get("from"), q.Get("to"), q.Get("range")
+	for _, d := range []string{f.From, f.To} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse(DateLayout, d); err != nil {
+			return f, BadRequest("from and to must be YYYY-MM-DD dates")
+		}
+	}
+	if f.Range != "" {
+		if f.From != "" || f.To != "" {
+			return f, BadRequest("range can't be combined with from and to")
+		}
+		if _, _, err := ResolvePeriod(f.Range, Today()); err != nil {
+			return f, err
+		}
+	}
+
+	if v := q.Get("cleared"); v != "" {
+		cleared, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, BadRequest("invalid cleared")
+		}
+		f.Cleared = &cleared
+	}
+
+	f.Direction = q.Get("direction")
+	if f.Direction != "" && f.Direction != DirectionInflow && f.Direction != DirectionOutflow {
+		return f, BadRequest("direction must be inflow or outflow")
+	}
+
+	seen := map[string]bool{}
+	for _, v := range q["tag"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = NormalizeTag(name); name != "" && !seen[name] {
+				seen[name] = true
+				f.Tags = append(f.Tags, name)
+			}
+		}
+	}
+
+	f.TagMode = q.Get("tag_mode")
+	if f.TagMode == "" {
+		f.TagMode = TagModeAny
+	}
+	if f.TagMode != TagModeAny && f.TagMode != TagModeAll {
+		return f, BadRequest("tag_mode must be any or all")
+	}
+	return f, nil
+}
+
+// where is the filter as conditions on transactions t, with their args
+func (f TransactionFilter) where() ([]string, []any, error) {
+	conditions := []string{"t.is_deleted = 0"}
+	var args []any
+	add := func(cond string, condArgs ...any) {
+		conditions = append(conditions, cond)
+		args = append(args, condArgs...)
+	}
+
+	if len(f.IDs) > 0 {
+		cond, condArgs, err := sqlx.In("t.id IN (?)", f.IDs)
+		if err != nil {
+			return nil, nil, err
+		}
+		add(cond, condArgs...)
+	}
+	if f.AccountID != nil {
+		add("t.account_id = ?", *f.AccountID)
+	}
+	if f.CategoryID != nil {
+		add(`t.category_id IN (
+			WITH RECURSIVE sub(id) AS (
+				SELECT ?
+				UNION
+				SELECT c.id FROM categories c JOIN sub ON c.parent_id = sub.id
+			)
+			SELECT id FROM sub
+		)`, *f.CategoryID)
+	}
+	if f.Payee != "" {
+		add("LOWER(t.payee) LIKE '%' || LOWER(?) || '%'", f.Payee)
+	}
+
+	from, to := f.From, f.To
+	if f.Range != "" {
+		start, end, err := ResolvePeriod(f.Range, Today())
+		if err != nil {
+			return nil, nil, err
+		}
+		from, to = start.Format(DateLayout), end.Format(DateLayout)
+	}
+	if from != "" {
+		add("date(t.date) >= ?", from)
+	}
+	if to != "" {
+		add("date(t.date) <= ?", to)
+	}
+
+	if f.Cleared != nil {
+		add("t.cleared = ?", *f.Cleared)
+	}
+	switch f.Direction {
+	case DirectionInflow:
+		add("t.amount < 0")
+	case DirectionOutflow:
+		add("t.amount >= 0")
+	}
+	if f.MinAmount != nil {
+		add("ABS(t.amount) >= ?", *f.MinAmount)
+	}
+	if f.MaxAmount != nil {
+		add("ABS(t.amount) <= ?", *f.MaxAmount)
+	}
+
+	if len(f.Tags) > 0 {
+		tagged := `
+			SELECT COUNT(DISTINCT g.id) FROM transaction_tags tt
+			JOIN tags g ON g.id = tt.tag_id
+			WHERE tt.transaction_id = t.id AND g.is_deleted = 0 AND g.name IN (?)`
+		want := 1
+		if f.TagMode == TagModeAll {
+			want = len(f.Tags)
+		}
+
+		cond, condArgs, err := sqlx.In("("+tagged+") >= ?", f.Tags, want)
+		if err != nil {
+			return nil, nil, err
+		}
+		add(cond, condArgs...)
+	}
+	return conditions, args, nil
+}
+
+// ListTransactions lists the live transactions matching f with their
+// account, category and tags
+func ListTransactions(f TransactionFilter) ([]TransactionWithRelations, error) {
+	conditions, args, err := f.where()
+	if err != nil {
+		return nil, err
+	}
+
+	txs := []TransactionWithRelations{}
+	err = db.Select(&txs, `
+		SELECT
+			t.*,
+			CASE WHEN t.amount < 0 THEN 'inflow' ELSE 'outflow' END AS direction,
+			a.name AS account_name,
+			c.name AS category_name
+		FROM transactions t
+		JOIN accounts a ON a.id = t.account_id
+		LEFT JOIN categories c ON c.id = t.category_id
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY date(t.date), t.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(txs))
+	for i := range txs {
+		ids[i] = txs[i].ID
+	}
+	tags, err := LoadTransactionTags(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range txs {
+		txs[i].Tags = tags[txs[i].ID]
+		if txs[i].Tags == nil {
+			txs[i].Tags = []string{}
+		}
+	}
+	return txs, nil
+}
+
+// ImportResult is the outcome of one imported transaction, in request order
+type ImportResult struct {
+	Index      int     `json:"index"`
+	ID         int64   `json:"id,omitempty"`
+	CategoryID *int64  `json:"category_id,omitempty"`
+	RuleIDs    []int64 `json:"rule_ids,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// ImportTransactions creates each transaction the way POST /transactions
+// does, except that imports may stay uncategorized when no rule or payee
+// default categorizes them. The import is one database transaction: a row
+// that is invalid is reported and skipped, any other error rolls back all
+// of it.
+func ImportTransactions(reqs []CreaateTransactionRequest) ([]ImportResult, error) {
+	rules, err := LoadRules()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]ImportResult, len(reqs))
+	imported := 0
+	for i, req := range reqs {
+		res := &results[i]
+		res.Index = i
+
+		if req.AccountID == 0 {
+			res.Error = "account id is required"
+			continue
+		}
+
+		req, applied, err := PrepareTransaction(tx, rules, req)
+		if err == nil {
+			res.ID, err = InsertTransaction(tx, req.AccountID, req.CategoryID, req.Payee, req.Memo, req.Amount, req.Date)
+		}
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) {
+			res.ID, res.Error = 0, httpErr.Message
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.CategoryID, res.RuleIDs = req.CategoryID, applied
+		imported++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[DB][OK] import_transactions(count=%d) imported=%d\n", len(reqs), imported)
+	return results, nil
+}
+
+// HandleImportTransactions handles POST /transactions/import with a JSON
+// array of transactions
+func HandleImportTransactions(w http.ResponseWriter, r *http.Request) {
+	var reqs []CreaateTransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	results, err := ImportTransactions(reqs)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"status":  "OK",
+		"results": results,
+	})
+}
+
+const (
+	BulkSetCategory = "set_category"
+	BulkSetAccount  = "set_account"
+	BulkAddTag      = "add_tag"
+	BulkMarkCleared = "mark_cleared"
+	BulkDelete      = "delete"
+)
+
+// BulkTransactionRequest applies one operation to many transactions.
+// CategoryID, AccountID, Tag and Cleared are the operation's argument;
+// mark_cleared clears unless Cleared is false.
+type BulkTransactionRequest struct {
+	TransactionIDs []int64 `json:"transaction_ids"`
+	Op             string  `json:"op"`
+	CategoryID     *int64  `json:"category_id,omitempty"`
+	AccountID      *int64  `json:"account_id,omitempty"`
+	Tag            string  `json:"tag,omitempty"`
+	Cleared        *bool   `json:"cleared,omitempty"`
+}
+
+// BulkResult is the outcome for one transaction: "ok", "unchanged" or
+// "error" with the reason
+type BulkResult struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (r BulkTransactionRequest) validate(tx *sqlx.Tx) error {
+	if len(r.TransactionIDs) == 0 {
+		return BadRequest("transaction_ids is required")
+	}
+
+	alive := func(table, noun string, id int64) error {
+		var n int
+		err := tx.Get(&n, `SELECT COUNT(*) FROM `+table+` WHERE id = ? AND is_deleted = 0`, id)
+		if err == nil && n == 0 {
+			err = NotFound("%s %d not found", noun, id)
+		}
+		return err
+	}
+
+	switch r.Op {
+	case BulkSetCategory:
+		if r.CategoryID == nil {
+			return BadRequest("category_id is required")
+		}
+		return alive("categories", "category", *r.CategoryID)
+	case BulkSetAccount:
+		if r.AccountID == nil {
+			return BadRequest("account_id is required")
+		}
+		return alive("accounts", "account", *r.AccountID)
+	case BulkAddTag:
+		if NormalizeTag(r.Tag) == "" {
+			return BadRequest("tag is required")
+		}
+	case BulkMarkCleared, BulkDelete:
+	default:
+		return BadRequest("op must be one of set_category, set_account, add_tag, mark_cleared, delete")
+	}
+	return nil
+}
+
+// applyBulk applies the operation to one transaction. Moving a transaction
+// between categories or accounts reverses its effect on the old ones and
+// applies it to the new ones. The sign of an amount depends on whether its
+// category is an income one, so transactions don't move between income and
+// spending categories.
+func (r BulkTransactionRequest) applyBulk(tx *sqlx.Tx, t *Transaction) (bool, error) {
+	switch r.Op {
+	case BulkSetCategory:
+		if t.TransferAccountID != nil {
+			return false, BadRequest("transfers have no category")
+		}
+		if sameID(t.CategoryID, r.CategoryID) {
+			return false, nil
+		}
+		if t.CategoryID != nil {
+			var kinds int
+			err := tx.Get(&kinds, `SELECT COUNT(DISTINCT is_income) FROM categories WHERE id IN (?, ?)`, *t.CategoryID, *r.CategoryID)
+			if err != nil {
+				return false, err
+			}
+			if kinds > 1 {
+				return false, BadRequest("can't move a transaction between an income and a spending category")
+			}
+		}
+		if err := applyTransactionEffect(tx, t, -1); err != nil {
+			return false, err
+		}
+		t.CategoryID = r.CategoryID
+		if err := applyTransactionEffect(tx, t, 1); err != nil {
+			return false, err
+		}
+		_, err := tx.Exec(`UPDATE transactions SET category_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, t.CategoryID, t.ID)
+		return true, err
+
+	case BulkSetAccount:
+		if t.AccountID == *r.AccountID {
+			return false, nil
+		}
+		if sameID(t.TransferAccountID, r.AccountID) {
+			return false, BadRequest("a transfer can't be moved to its other account")
+		}
+		if err := applyTransactionEffect(tx, t, -1); err != nil {
+			return false, err
+		}
+		t.AccountID = *r.AccountID
+		if err := applyTransactionEffect(tx, t, 1); err != nil {
+			return false, err
+		}
+		_, err := tx.Exec(`UPDATE transactions SET account_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, t.AccountID, t.ID)
+		return true, err
+
+	case BulkAddTag:
+		n, err := AddTags(tx, []int64{t.ID}, []string{r.Tag})
+		return n > 0, err
+
+	case BulkMarkCleared:
+		cleared := r.Cleared == nil || *r.Cleared
+		if t.Cleared == cleared {
+			return false, nil
+		}
+		_, err := tx.Exec(`UPDATE transactions SET cleared = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, cleared, t.ID)
+		return true, err
+
+	case BulkDelete:
+		if err := applyTransactionEffect(tx, t, -1); err != nil {
+			return false, err
+		}
+		_, err := tx.Exec(`UPDATE transactions SET is_deleted = 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, t.ID)
+		return true, err
+	}
+	return false, nil
+}
+
+// BulkUpdateTransactions applies req to each of its transactions in one SQL
+// transaction. Transactions that are missing or can't take the operation
+// are reported and left alone; a database error rolls everything back.
+func BulkUpdateTransactions(req BulkTransactionRequest) ([]BulkResult, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := req.validate(tx); err != nil {
+		return nil, err
+	}
+
+	results := make([]BulkResult, len(req.TransactionIDs))
+	changed := 0
+	for i, id := range req.TransactionIDs {
+		res := &results[i]
+		res.ID = id
+
+		var t Transaction
+		err := tx.Get(&t, `SELECT * FROM transactions WHERE id = ? AND is_deleted = 0`, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			res.Status, res.Error = "error", "transaction not found"
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := req.applyBulk(tx, &t)
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) {
+			res.Status, res.Error = "error", httpErr.Message
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		res.Status = "unchanged"
+		if ok {
+			res.Status = "ok"
+			changed++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[DB][OK] bulk_transactions(op=%s, count=%d) changed=%d\n", req.Op, len(req.TransactionIDs), changed)
+	return results, nil
+}
+
+// HandleBulkTransactions handles POST /transactions/bulk
+func HandleBulkTransactions(w http.ResponseWriter, r *http.Request) {
+	var req BulkTransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	results, err := BulkUpdateTransactions(req)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"status":  "OK",
+		"results": results,
+	})
+}
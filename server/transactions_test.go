@@ -0,0 +1,129 @@
+package main
+
+import "testing"
+
+// ledger is the fixture the transaction tests start from: one account and
+// two spending categories and an income one, each holding 100
+type ledger struct {
+	account, food, transport, salary int64
+}
+
+func newLedger(t *testing.T) ledger {
+	t.Helper()
+	openTestDB(t)
+
+	return ledger{
+		account:   mustExec(t, `INSERT INTO accounts (name, type, balance) VALUES ('Bank', 'checking', 1000)`),
+		food:      mustExec(t, `INSERT INTO categories (name, amount) VALUES ('Food', 100)`),
+		transport: mustExec(t, `INSERT INTO categories (name, amount) VALUES ('Transport', 100)`),
+		salary:    mustExec(t, `INSERT INTO categories (name, amount, is_income) VALUES ('Salary', 100, 1)`),
+	}
+}
+
+func TestBulkSetCategory(t *testing.T) {
+	tests := []struct {
+		name string
+		// from is the transaction's category, nil when uncategorized
+		from   func(l ledger) *int64
+		to     func(l ledger) int64
+		amount float64
+		status string
+		// want are the category amounts after the move, by category
+		want func(l ledger) map[int64]float64
+	}{
+		{
+			name:   "between spending categories",
+			from:   func(l ledger) *int64 { return &l.food },
+			to:     func(l ledger) int64 { return l.transport },
+			amount: 30,
+			status: "ok",
+			want: func(l ledger) map[int64]float64 {
+				return map[int64]float64{l.food: 100, l.transport: 70, l.salary: 100}
+			},
+		},
+		{
+			name:   "refund between spending categories",
+			from:   func(l ledger) *int64 { return &l.food },
+			to:     func(l ledger) int64 { return l.transport },
+			amount: -20,
+			status: "ok",
+			want: func(l ledger) map[int64]float64 {
+				return map[int64]float64{l.food: 100, l.transport: 120, l.salary: 100}
+			},
+		},
+		{
+			name:   "uncategorized into a category",
+			from:   func(l ledger) *int64 { return nil },
+			to:     func(l ledger) int64 { return l.food },
+			amount: 30,
+			status: "ok",
+			want: func(l ledger) map[int64]float64 {
+				return map[int64]float64{l.food: 70, l.transport: 100, l.salary: 100}
+			},
+		},
+		{
+			name:   "same category",
+			from:   func(l ledger) *int64 { return &l.food },
+			to:     func(l ledger) int64 { return l.food },
+			amount: 30,
+			status: "unchanged",
+			want: func(l ledger) map[int64]float64 {
+				return map[int64]float64{l.food: 70, l.transport: 100, l.salary: 100}
+			},
+		},
+		{
+			name:   "spending into income",
+			from:   func(l ledger) *int64 { return &l.food },
+			to:     func(l ledger) int64 { return l.salary },
+			amount: 30,
+			status: "error",
+			want: func(l ledger) map[int64]float64 {
+				return map[int64]float64{l.food: 70, l.transport: 100, l.salary: 100}
+			},
+		},
+		{
+			name:   "income into spending",
+			from:   func(l ledger) *int64 { return &l.salary },
+			to:     func(l ledger) int64 { return l.food },
+			amount: -50,
+			status: "error",
+			want: func(l ledger) map[int64]float64 {
+				return map[int64]float64{l.food: 100, l.transport: 100, l.salary: 150}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLedger(t)
+
+			id, err := CreateTransaction(l.account, tt.from(l), nil, nil, tt.amount, "2026-01-15")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			to := tt.to(l)
+			results, err := BulkUpdateTransactions(BulkTransactionRequest{
+				TransactionIDs: []int64{id},
+				Op:             BulkSetCategory,
+				CategoryID:     &to,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 || results[0].Status != tt.status {
+				t.Fatalf("results = %+v, want status %s", results, tt.status)
+			}
+
+			b := loadBalances(t)
+			if got, want := b.Accounts[l.account], 1000-tt.amount; got != want {
+				t.Errorf("account balance = %v, want %v", got, want)
+			}
+			for category, want := range tt.want(l) {
+				if got := b.Categories[category]; got != want {
+					t.Errorf("category %d amount = %v, want %v", category, got, want)
+				}
+			}
+		})
+	}
+}
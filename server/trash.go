@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultPurgeDays is how long soft-deleted rows stay in the trash when the
+// purge request doesn't say otherwise
+const DefaultPurgeDays = 30
+
+// TrashEntity wires one table into the trash endpoints
+type TrashEntity struct {
+	List    func() (any, error)
+	Restore func(id int64) error
+	Purge   func(olderThanDays int) (int64, error)
+}
+
+var trashEntities = map[string]TrashEntity{
+	"categories": {
+		List: func() (any, error) {
+			return listDeleted(NewRepository[Category](db, "categories", "id"))
+		},
+		Restore: RestoreCategory,
+		Purge:   PurgeCategories,
+	},
+	"accounts": {
+		List: func() (any, error) {
+			return listDeleted(NewRepository[Account](db, "accounts", "id"))
+		},
+		Restore: func(id int64) error {
+			return NewRepository[Account](db, "accounts", "id").Restore(id)
+		},
+		Purge: PurgeAccounts,
+	},
+	"transactions": {
+		List: func() (any, error) {
+			return listDeleted(NewRepository[Transaction](db, "transactions", "id"))
+		},
+		Restore: RestoreTransaction,
+		Purge:   PurgeTransactions,
+	},
+	"attachments": {
+		List: listDeletedAttachments,
+		Restore: func(id int64) error {
+			return NewRepository[Attachment](db, "attachments", "id").Restore(id)
+		},
+		Purge: PurgeAttachments,
+	},
+}
+
+func listDeleted[T Entity](repo *Repository[T]) ([]T, error) {
+	items, err := repo.ListDeleted(WithOrderBy("updated_at DESC"))
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = []T{}
+	}
+	return items, nil
+}
+
+// purgeCutoff is the datetime() modifier selecting rows deleted at least
+// olderThanDays ago
+func purgeCutoff(olderThanDays int) string {
+	return fmt.Sprintf("-%d days", olderThanDays)
+}
+
+// RestoreCategory brings a category back from the trash. If its parent is
+// still deleted it is reattached at the root, and it is placed after its
+// new siblings.
+func RestoreCategory(id int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var c Category
+	err = tx.Get(&c, `SELECT * FROM categories WHERE id = ? AND is_deleted = 1`, id)
+	if err != nil {
+		return err
+	}
+
+	parentID := c.ParentID
+	if parentID != nil {
+		var alive int
+		err = tx.Get(&alive, `SELECT COUNT(*) FROM categories WHERE id = ? AND is_deleted = 0`, *parentID)
+		if err != nil {
+			return err
+		}
+		if alive == 0 {
+			parentID = nil
+		}
+	}
+
+	var sortOrder int64
+	err = tx.Get(&sortOrder, `
+		SELECT COALESCE(MAX(sort_order) + 1, 0)
+		FROM categories
+		WHERE is_deleted = 0 AND parent_id IS ?
+	`, parentID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE categories
+		SET is_deleted = 0, parent_id = ?, sort_order = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, parentID, sortOrder, id)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("[DB][OK] restore_category(id=%d, parent_id=%v, sort_order=%d)\n", id, parentID, sortOrder)
+	return nil
+}
+
+// RestoreTransaction brings a transaction back from the trash and re-applies
+// its balance and category effects. Its account and category must be live.
+func RestoreTransaction(id int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var t Transaction
+	err = tx.Get(&t, `SELECT * FROM transactions WHERE id = ? AND is_deleted = 1`, id)
+	if err != nil {
+		return err
+	}
+
+	var alive int
+	err = tx.Get(&alive, `SELECT COUNT(*) FROM accounts WHERE id = ? AND is_deleted = 0`, t.AccountID)
+	if err != nil {
+		return err
+	}
+	if alive == 0 {
+		return Conflict("account %d is deleted, restore it first", t.AccountID)
+	}
+
+	if t.CategoryID != nil {
+		err = tx.Get(&alive, `SELECT COUNT(*) FROM categories WHERE id = ? AND is_deleted = 0`, *t.CategoryID)
+		if err != nil {
+			return err
+		}
+		if alive == 0 {
+			return Conflict("category %d is deleted, restore it first", *t.CategoryID)
+		}
+	}
+
+	if err := applyTransactionEffect(tx, &t, 1); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE transactions SET is_deleted = 0, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("[DB][OK] restore_transaction(id=%d)\n", id)
+	return nil
+}
+
+// PurgeTransactions permanently removes transactions deleted at least
+// olderThanDays ago, with their tags, attachments and schedule exceptions
+func PurgeTransactions(olderThanDays int) (int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cutoff := purgeCutoff(olderThanDays)
+	purgeable := `SELECT id FROM transactions WHERE is_deleted = 1 AND updated_at <= datetime('now', ?)`
+
+	// A postponed occurrence whose transaction is gone was still posted, so
+	// its exception goes rather than being posted again
+	for _, table := range []string{"transaction_tags", "attachments", "schedule_exceptions"} {
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE transaction_id IN (`+purgeable+`)`, cutoff)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := tx.Exec(`DELETE FROM transactions WHERE id IN (`+purgeable+`)`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("[DB][OK] purge_transactions(older_than_days=%d) removed=%d\n", olderThanDays, n)
+	return n, nil
+}
+
+// PurgeCategories permanently removes categories deleted at least
+// olderThanDays ago. Rows still pointing at them are detached first.
+func PurgeCategories(olderThanDays int) (int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cutoff := purgeCutoff(olderThanDays)
+	purgeable := `SELECT id FROM categories WHERE is_deleted = 1 AND updated_at <= datetime('now', ?)`
+
+	_, err = tx.Exec(`UPDATE transactions SET category_id = NULL WHERE category_id IN (`+purgeable+`)`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, table := range []string{"budgets", "goals"} {
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE category_id IN (`+purgeable+`)`, cutoff)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// A rule that only sets the category would be left doing nothing
+	_, err = tx.Exec(`
+		DELETE FROM rules
+		WHERE set_category_id IN (`+purgeable+`) AND set_payee IS NULL AND set_memo IS NULL
+	`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, ref := range []string{"schedules.category_id", "payees.default_category_id", "rules.set_category_id"} {
+		table, column, _ := strings.Cut(ref, ".")
+		_, err = tx.Exec(`UPDATE `+table+` SET `+column+` = NULL WHERE `+column+` IN (`+purgeable+`)`, cutoff)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE categories SET parent_id = NULL WHERE parent_id IN (`+purgeable+`)`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(`DELETE FROM categories WHERE id IN (`+purgeable+`)`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("[DB][OK] purge_categories(older_than_days=%d) removed=%d\n", olderThanDays, n)
+	return n, nil
+}
+
+// PurgeAccounts permanently removes accounts deleted at least olderThanDays
+// ago. Accounts that transactions still reference, deleted or not, are kept.
+// The schedules posting to a purged account and the rules limited to it go
+// with it.
+func PurgeAccounts(olderThanDays int) (int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	purgeable := `
+		SELECT id FROM accounts
+		WHERE is_deleted = 1
+		  AND updated_at <= datetime('now', ?)
+		  AND NOT EXISTS (
+			SELECT 1 FROM transactions t
+			WHERE t.account_id = accounts.id OR t.transfer_account_id = accounts.id
+		  )`
+	cutoff := purgeCutoff(olderThanDays)
+
+	_, err = tx.Exec(`
+		DELETE FROM schedule_exceptions
+		WHERE schedule_id IN (SELECT id FROM schedules WHERE account_id IN (`+purgeable+`))
+	`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, table := range []string{"schedules", "rules"} {
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE account_id IN (`+purgeable+`)`, cutoff)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := tx.Exec(`DELETE FROM accounts WHERE id IN (`+purgeable+`)`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("[DB][OK] purge_accounts(older_than_days=%d) removed=%d\n", olderThanDays, n)
+	return n, nil
+}
+
+func trashEntity(r *http.Request) (TrashEntity, error) {
+	entity, ok := trashEntities[r.PathValue("entity")]
+	if !ok {
+		return TrashEntity{}, NotFound("Unknown entity %q", r.PathValue("entity"))
+	}
+	return entity, nil
+}
+
+// HandleListTrash handles GET /trash/{entity}
+func HandleListTrash(w http.ResponseWriter, r *http.Request) {
+	entity, err := trashEntity(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	items, err := entity.List()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, items)
+}
+
+// HandleRestoreFromTrash handles POST /trash/{entity}/{id}/restore
+func HandleRestoreFromTrash(w http.ResponseWriter, r *http.Request) {
+	entity, err := trashEntity(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	id, err := PathID(r, "id")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	if err := entity.Restore(id); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]string{
+		"status": "OK",
+	})
+}
+
+// HandlePurgeTrash handles DELETE /trash/{entity}?older_than_days=N
+func HandlePurgeTrash(w http.ResponseWriter, r *http.Request) {
+	entity, err := trashEntity(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	days := DefaultPurgeDays
+	if v := r.URL.Query().Get("older_than_days"); v != "" {
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 0 {
+			WriteError(w, BadRequest("Invalid older_than_days"))
+			return
+		}
+	}
+
+	n, err := entity.Purge(days)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	WriteJSON(w, map[string]any{
+		"status": "OK",
+		"purged": n,
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRestoreTransaction(t *testing.T) {
+	tests := []struct {
+		name string
+		// trash deletes whatever else the test needs deleted
+		trash    func(t *testing.T, l ledger)
+		conflict bool
+		// wantAccount and wantFood are the balances after the restore
+		wantAccount, wantFood float64
+	}{
+		{
+			name:        "restored",
+			trash:       func(t *testing.T, l ledger) {},
+			wantAccount: 970,
+			wantFood:    70,
+		},
+		{
+			name: "category deleted",
+			trash: func(t *testing.T, l ledger) {
+				mustExec(t, `UPDATE categories SET is_deleted = 1 WHERE id = ?`, l.food)
+			},
+			conflict:    true,
+			wantAccount: 1000,
+			wantFood:    100,
+		},
+		{
+			name: "account deleted",
+			trash: func(t *testing.T, l ledger) {
+				mustExec(t, `UPDATE accounts SET is_deleted = 1 WHERE id = ?`, l.account)
+			},
+			conflict:    true,
+			wantAccount: 1000,
+			wantFood:    100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLedger(t)
+
+			id, err := CreateTransaction(l.account, &l.food, nil, nil, 30, "2026-01-15")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := DeleteTransaction(id); err != nil {
+				t.Fatal(err)
+			}
+			tt.trash(t, l)
+
+			err = RestoreTransaction(id)
+			var httpErr *HTTPError
+			if tt.conflict {
+				if !errors.As(err, &httpErr) || httpErr.Status != 409 {
+					t.Fatalf("err = %v, want a conflict", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			b := loadBalances(t)
+			if got := b.Accounts[l.account]; got != tt.wantAccount {
+				t.Errorf("account balance = %v, want %v", got, tt.wantAccount)
+			}
+			if got := b.Categories[l.food]; got != tt.wantFood {
+				t.Errorf("food amount = %v, want %v", got, tt.wantFood)
+			}
+		})
+	}
+
+	t.Run("twice", func(t *testing.T) {
+		l := newLedger(t)
+
+		id, err := CreateTransaction(l.account, &l.food, nil, nil, 30, "2026-01-15")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := RestoreTransaction(id); err == nil {
+			t.Fatal("restored a live transaction")
+		}
+		if got := loadBalances(t).Accounts[l.account]; got != 970 {
+			t.Errorf("account balance = %v, want 970", got)
+		}
+	})
+}
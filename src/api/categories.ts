@@ -38,7 +38,7 @@ export function reorderCategory(input: {
   oldIndex?: number;
   newIndex?: number;
 }) {
-  return api<Category>(`/categories/reorder/${input.id}`, {
+  return api<Category>(`/categories/${input.id}/reorder`, {
     method: "PUT",
     body: JSON.stringify(input),
   });